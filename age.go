@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Objects can be written in the age v1 format, https://age-encryption.org/v1,
+// so they can be decrypted with the age CLI without vstore. Shared objects are
+// encrypted to the X25519 keys of their recipients, the others with the
+// master key as scrypt passphrase. age has no associated data nor padding: the
+// location of the object is the first line of its plaintext, checked on open,
+// and its length isn't hidden. Every object costs a scrypt of the master key.
+const (
+	FORMAT_VSTORE = "vstore"
+	FORMAT_AGE    = "age"
+
+	AGE_HEADER        = "age-encryption.org/v1\n"
+	AGE_SCRYPT_STANZA = "\n-> scrypt "
+	AGE_HEADER_END    = "\n--- "
+	AGE_LOCATION_LINE = "vstore-location: "
+	// scrypt cost of written objects and highest one accepted on open, the
+	// age default
+	AGE_SCRYPT_WORK_FACTOR = 18
+)
+
+// IsAgeFile tells whether data is in the age v1 format.
+func IsAgeFile(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(AGE_HEADER))
+}
+
+// IsAgePassphraseFile tells whether the age file data is encrypted with a
+// passphrase, the master key, rather than to recipients.
+func IsAgePassphraseFile(data []byte) bool {
+	end := bytes.Index(data, []byte(AGE_HEADER_END))
+	return end >= 0 && bytes.Contains(data[:end], []byte(AGE_SCRYPT_STANZA))
+}
+
+func ParseFormat(name string) (string, error) {
+	switch name {
+	case "", FORMAT_VSTORE:
+		return FORMAT_VSTORE, nil
+	case FORMAT_AGE:
+		return FORMAT_AGE, nil
+	}
+	return "", fmt.Errorf("unknown object format %v", name)
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+func bech32Polymod(values []byte) uint32 {
+	generator := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if top>>uint(i)&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+// bech32Encode encodes data with the lower case human readable part hrp, as
+// age does for its keys.
+func bech32Encode(hrp string, data []byte) string {
+	var values []byte
+	acc, bits := uint32(0), uint(0)
+	for _, b := range data {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			values = append(values, byte(acc>>bits)&31)
+		}
+	}
+	if bits > 0 {
+		values = append(values, byte(acc<<(5-bits))&31)
+	}
+	expanded := []byte{}
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c>>5)
+	}
+	expanded = append(expanded, 0)
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c&31)
+	}
+	checksumInput := append(append(expanded, values...), 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(checksumInput) ^ 1
+	var encoded strings.Builder
+	encoded.WriteString(hrp)
+	encoded.WriteString("1")
+	for _, v := range values {
+		encoded.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		encoded.WriteByte(bech32Charset[mod>>uint(5*(5-i))&31])
+	}
+	return encoded.String()
+}
+
+// AgeRecipient returns the age encoding, age1..., of an X25519 public key.
+func AgeRecipient(publicKey [X25519_KEY_BYTES]byte) string {
+	return bech32Encode("age", publicKey[:])
+}
+
+// AgeIdentity returns the age encoding, AGE-SECRET-KEY-1..., of an encoded
+// identity.
+func AgeIdentity(identity string) (string, error) {
+	private, err := decodeX25519Key(identity)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(bech32Encode("age-secret-key-", private[:])), nil
+}
+
+// SealAge encrypts plaintext for location in the age format, to recipients
+// when there are some, with masterKey as passphrase otherwise.
+func SealAge(plaintext []byte, recipients []recipient, masterKey string, location string) ([]byte, error) {
+	ageRecipients := []age.Recipient{}
+	for _, r := range recipients {
+		ageRecipient, err := age.ParseX25519Recipient(AgeRecipient(r.PublicKey))
+		if err != nil {
+			return nil, err
+		}
+		ageRecipients = append(ageRecipients, ageRecipient)
+	}
+	if len(ageRecipients) == 0 {
+		if masterKey == "" {
+			return nil, errors.New("no master key to encrypt with")
+		}
+		ageRecipient, err := age.NewScryptRecipient(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		ageRecipient.SetWorkFactor(AGE_SCRYPT_WORK_FACTOR)
+		ageRecipients = append(ageRecipients, ageRecipient)
+	}
+	var buf bytes.Buffer
+	writer, err := age.Encrypt(&buf, ageRecipients...)
+	if err != nil {
+		HandleErr(err, "Couldn't start age encryption")
+		return nil, err
+	}
+	_, err = writer.Write([]byte(AGE_LOCATION_LINE + location + "\n"))
+	if err == nil {
+		_, err = writer.Write(plaintext)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		HandleErr(err, "Couldn't encrypt with age")
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// OpenAge decrypts the age file at location, with the master key when it is
+// encrypted with a passphrase and with the identity otherwise.
+func OpenAge(data []byte, identity string, masterKey string, location string) ([]byte, error) {
+	identities := []age.Identity{}
+	passphrase := IsAgePassphraseFile(data)
+	if identity != "" && !passphrase {
+		encoded, err := AgeIdentity(identity)
+		if err != nil {
+			return nil, err
+		}
+		ageIdentity, err := age.ParseX25519Identity(encoded)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, ageIdentity)
+	}
+	if masterKey != "" && passphrase {
+		ageIdentity, err := age.NewScryptIdentity(masterKey)
+		if err != nil {
+			return nil, err
+		}
+		ageIdentity.SetMaxWorkFactor(AGE_SCRYPT_WORK_FACTOR)
+		identities = append(identities, ageIdentity)
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("no identity nor master key to decrypt with")
+	}
+	reader, err := age.Decrypt(bytes.NewReader(data), identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, ErrNotRecipient
+	}
+	if err != nil {
+		HandleErr(err, "Couldn't decrypt age file")
+		return nil, err
+	}
+	plaintext, err := ioutil.ReadAll(io.LimitReader(reader, int64(len(data))))
+	if err != nil {
+		HandleErr(err, "Couldn't decrypt age file")
+		return nil, err
+	}
+	line := AGE_LOCATION_LINE + location + "\n"
+	if !bytes.HasPrefix(plaintext, []byte(line)) {
+		return nil, ErrObjectRelocated
+	}
+	return plaintext[len(line):], nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestAgeKeyEncoding(t *testing.T) {
+	identity, r := testRecipient(t, "alice")
+	encoded, err := AgeIdentity(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ageIdentity, err := age.ParseX25519Identity(encoded)
+	if err != nil {
+		t.Fatal("Expecting age to parse the identity", err)
+	}
+	if ageIdentity.Recipient().String() != AgeRecipient(r.PublicKey) {
+		t.Error("Expecting the age recipient to match the identity, got", AgeRecipient(r.PublicKey))
+	}
+}
+
+func TestSealOpenAge(t *testing.T) {
+	aliceIdentity, alice := testRecipient(t, "alice")
+	bobIdentity, _ := testRecipient(t, "bob")
+	keys := &Keyring{Identity: aliceIdentity, Format: FORMAT_AGE}
+	sealed, err := keys.SealFor([]byte("{\"pin\":\"1234\"}"), "bank/card", []recipient{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAgeFile(sealed) || IsAgePassphraseFile(sealed) {
+		t.Error("Expecting an age file encrypted to recipients")
+	}
+	plaintext, err := keys.openShared(sealed, "bank/card", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "{\"pin\":\"1234\"}" {
+		t.Error("Expecting content to round trip, got", string(plaintext))
+	}
+	_, err = keys.openShared(sealed, "bank/other", true)
+	if err != ErrObjectRelocated {
+		t.Error("Expecting a relocation error when opening at another location, got", err)
+	}
+	_, err = keys.openShared(sealed, "bank/card", false)
+	if err != ErrWrongSealing {
+		t.Error("Expecting content encrypted to recipients to be refused outside shared directories, got", err)
+	}
+	other := &Keyring{Identity: bobIdentity, Format: FORMAT_AGE}
+	_, err = other.openShared(sealed, "bank/card", true)
+	if err != ErrNotRecipient {
+		t.Error("Expecting a not recipient error when opening with another identity, got", err)
+	}
+	keys.Format = FORMAT_VSTORE
+	_, err = keys.openShared(sealed, "bank/card", true)
+	if err == nil {
+		t.Error("Expecting age files encrypted to recipients to be refused without the age format")
+	}
+}
+
+func TestSealOpenAgePassphrase(t *testing.T) {
+	keys := &Keyring{MasterKey: "testmasterkey", Format: FORMAT_AGE}
+	sealed, err := keys.SealFor([]byte("{}"), "notes/todo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsAgePassphraseFile(sealed) {
+		t.Error("Expecting an age file encrypted with the master key")
+	}
+	// the master key is needed to write them, they are read whatever the format
+	keys.Format = FORMAT_VSTORE
+	plaintext, err := keys.openShared(sealed, "notes/todo", false)
+	if err != nil || string(plaintext) != "{}" {
+		t.Error("Expecting content to round trip, got", string(plaintext), err)
+	}
+	_, err = keys.openShared(sealed, "notes/todo", true)
+	if err != ErrWrongSealing {
+		t.Error("Expecting content encrypted with the master key to be refused in shared directories, got", err)
+	}
+}
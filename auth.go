@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// Authentication to the remote is configured in the settings, encrypted with
+// them, and applied to every clone, fetch, pull and push. Mirrors have their
+// own, origin's credentials are never sent to them.
+const (
+	AUTH_NONE              = ""
+	AUTH_SSH_KEY           = "ssh-key"
+	AUTH_SSH_AGENT         = "ssh-agent"
+	AUTH_HTTPS             = "https"
+	AUTH_CREDENTIAL_HELPER = "credential-helper"
+	AUTH_DEFAULT_SSH_USER  = "git"
+	AUTH_REMOTE_FLAG       = "--remote"
+)
+
+type authsettings struct {
+	Method string `json:"method"`
+	// User is the SSH user, git by default, or the HTTPS user name.
+	User string `json:"user,omitempty"`
+	// KeyPath and Passphrase are the SSH private key file and its
+	// passphrase.
+	KeyPath    string `json:"key_path,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	// Token is the HTTPS password or access token.
+	Token string `json:"token,omitempty"`
+}
+
+// GitAuth returns the go-git authentication for origin at remoteURL, nil to
+// let go-git pick its defaults.
+func (keys *Keyring) GitAuth(remoteURL string) (transport.AuthMethod, error) {
+	return gitAuth(keys.Auth, remoteURL)
+}
+
+// gitAuth returns the go-git authentication described by auth for the remote
+// at remoteURL.
+func gitAuth(auth *authsettings, remoteURL string) (transport.AuthMethod, error) {
+	if auth == nil || auth.Method == AUTH_NONE {
+		return nil, nil
+	}
+	sshUser := auth.User
+	if sshUser == "" {
+		sshUser = AUTH_DEFAULT_SSH_USER
+	}
+	switch auth.Method {
+	case AUTH_SSH_KEY:
+		method, err := ssh.NewPublicKeysFromFile(sshUser, auth.KeyPath, auth.Passphrase)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't load the SSH key %v", auth.KeyPath))
+			return nil, err
+		}
+		return method, nil
+	case AUTH_SSH_AGENT:
+		method, err := ssh.NewSSHAgentAuth(sshUser)
+		if err != nil {
+			HandleErr(err, "Couldn't reach the SSH agent")
+			return nil, err
+		}
+		return method, nil
+	case AUTH_HTTPS:
+		return &http.BasicAuth{Username: auth.User, Password: auth.Token}, nil
+	case AUTH_CREDENTIAL_HELPER:
+		return credentialFill(remoteURL)
+	}
+	return nil, fmt.Errorf("unknown auth method %v", auth.Method)
+}
+
+// RepoAuth returns the authentication for the store remote.
+func (keys *Keyring) RepoAuth(repo *git.Repository) (transport.AuthMethod, error) {
+	remote, err := repo.Remote(REMOTE_NAME)
+	if err != nil {
+		return nil, err
+	}
+	urls := remote.Config().URLs
+	if len(urls) == 0 {
+		return nil, errors.New("the remote has no URL")
+	}
+	return keys.GitAuth(urls[0])
+}
+
+// credentialRequest returns the git credential protocol description of an
+// HTTP(S) remote.
+func credentialRequest(remoteURL string) (string, error) {
+	u, err := url.Parse(remoteURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return "", fmt.Errorf("the git credential helper only applies to HTTP(S) remotes, not %v", remoteURL)
+	}
+	request := fmt.Sprintf("protocol=%s\nhost=%s\n", u.Scheme, u.Host)
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		request += fmt.Sprintf("path=%s\n", path)
+	}
+	if u.User != nil && u.User.Username() != "" {
+		request += fmt.Sprintf("username=%s\n", u.User.Username())
+	}
+	return request + "\n", nil
+}
+
+// parseCredential reads the user name and password answered by git
+// credential fill.
+func parseCredential(output []byte) (*http.BasicAuth, error) {
+	auth := &http.BasicAuth{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "=", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "username":
+			auth.Username = fields[1]
+		case "password":
+			auth.Password = fields[1]
+		}
+	}
+	if auth.Password == "" {
+		return nil, errors.New("the git credential helper returned no password")
+	}
+	return auth, nil
+}
+
+// credentialFill asks the git credential helpers for the credentials of the
+// remote, like git itself does.
+func credentialFill(remoteURL string) (*http.BasicAuth, error) {
+	request, err := credentialRequest(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(request)
+	output, err := cmd.Output()
+	if err != nil {
+		HandleErr(err, "Couldn't get credentials from git credential fill")
+		return nil, err
+	}
+	return parseCredential(output)
+}
+
+// remoteAuth returns the authentication settings of the remote name.
+func remoteAuth(settings usersettings, name string) (*authsettings, error) {
+	if name == REMOTE_NAME {
+		return settings.Auth, nil
+	}
+	for _, remote := range settings.Remotes {
+		if remote.Name == name {
+			return remote.Auth, nil
+		}
+	}
+	return nil, fmt.Errorf("no remote named %v", name)
+}
+
+// setRemoteAuth sets the authentication settings of the remote name.
+func setRemoteAuth(settings *usersettings, name string, auth *authsettings) error {
+	if name == REMOTE_NAME {
+		settings.Auth = auth
+		return nil
+	}
+	for i, remote := range settings.Remotes {
+		if remote.Name == name {
+			settings.Remotes[i].Auth = auth
+			return nil
+		}
+	}
+	return fmt.Errorf("no remote named %v", name)
+}
+
+// ConfigureAuth sets the authentication method of origin, or of the mirror
+// named after --remote, in the settings. Secrets are prompted for rather than
+// taken from the command line.
+func ConfigureAuth(password string, settings usersettings, args []string) error {
+	name := REMOTE_NAME
+	if len(args) >= 2 && args[0] == AUTH_REMOTE_FLAG {
+		name, args = args[1], args[2:]
+	}
+	current, err := remoteAuth(settings, name)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		method := "none"
+		if current != nil && current.Method != AUTH_NONE {
+			method = current.Method
+		}
+		fmt.Println(method)
+		return nil
+	}
+	auth := &authsettings{Method: args[0]}
+	switch {
+	case args[0] == "none" && len(args) == 1:
+		auth = nil
+	case args[0] == AUTH_SSH_KEY && (len(args) == 2 || len(args) == 3):
+		auth.KeyPath = args[1]
+		if len(args) == 3 {
+			auth.User = args[2]
+		}
+		fmt.Print("passphrase (empty for none): ")
+		fmt.Scanln(&auth.Passphrase)
+	case args[0] == AUTH_SSH_AGENT && len(args) <= 2:
+		if len(args) == 2 {
+			auth.User = args[1]
+		}
+	case args[0] == AUTH_HTTPS && len(args) == 2:
+		auth.User = args[1]
+		fmt.Print("password or token: ")
+		fmt.Scanln(&auth.Token)
+		if auth.Token == "" {
+			return errors.New("the token can't be empty")
+		}
+	case args[0] == AUTH_CREDENTIAL_HELPER && len(args) == 1:
+	default:
+		return fmt.Errorf("unknown auth method or arguments %v", strings.Join(args, " "))
+	}
+	err = setRemoteAuth(&settings, name, auth)
+	if err != nil {
+		return err
+	}
+	err = CreateEncodedSettingsFile(password, settings)
+	if err != nil {
+		HandleErr(err, "Couldn't save the settings")
+		return err
+	}
+	fmt.Println("Authentication updated")
+	return nil
+}
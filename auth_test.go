@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCredentialRequest(t *testing.T) {
+	request, err := credentialRequest("https://john@github.com/john/secrets.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "protocol=https\nhost=github.com\npath=john/secrets.git\nusername=john\n\n"
+	if request != expected {
+		t.Errorf("Unexpected request %q", request)
+	}
+	_, err = credentialRequest("git@github.com:john/secrets.git")
+	if err == nil {
+		t.Error("Expected an error for an SSH remote")
+	}
+}
+
+func TestParseCredential(t *testing.T) {
+	auth, err := parseCredential([]byte("protocol=https\nhost=github.com\nusername=john\npassword=s3cr=t\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Username != "john" || auth.Password != "s3cr=t" {
+		t.Errorf("Unexpected credentials %v %v", auth.Username, auth.Password)
+	}
+	_, err = parseCredential([]byte("protocol=https\nhost=github.com\n"))
+	if err == nil {
+		t.Error("Expected an error without a password")
+	}
+}
+
+func TestGitAuthNone(t *testing.T) {
+	keys := &Keyring{}
+	auth, err := keys.GitAuth("https://github.com/john/secrets.git")
+	if err != nil || auth != nil {
+		t.Errorf("Expected no authentication, got %v %v", auth, err)
+	}
+	keys.Auth = &authsettings{Method: AUTH_HTTPS, User: "john", Token: "t0ken"}
+	auth, err = keys.GitAuth("https://github.com/john/secrets.git")
+	if err != nil || auth.String() == "" {
+		t.Errorf("Expected basic authentication, got %v %v", auth, err)
+	}
+}
+
+func TestRemoteAuth(t *testing.T) {
+	originAuth := &authsettings{Method: AUTH_HTTPS, User: "john", Token: "t0ken"}
+	settings := usersettings{Remote: "https://github.com/john/secrets.git", Auth: originAuth, Remotes: []remotesettings{{Name: "nas", URL: "http://nas/secrets.git", Push: PUSH_ALWAYS}}}
+	auth, err := remoteAuth(settings, "nas")
+	if err != nil || auth != nil {
+		t.Errorf("Expecting a mirror not to get the credentials of origin, got %v %v", auth, err)
+	}
+	nasAuth := &authsettings{Method: AUTH_SSH_AGENT}
+	err = setRemoteAuth(&settings, "nas", nasAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Remotes[0].Auth != nasAuth || settings.Auth != originAuth {
+		t.Error("Expecting only the mirror authentication to change, got", settings.Remotes[0].Auth, settings.Auth)
+	}
+	if err = setRemoteAuth(&settings, "missing", nil); err == nil {
+		t.Error("Expecting an error for an unknown remote")
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// A transaction stages the writes of several operations in the worktree
+// instead of committing each of them. Committing it makes a single commit
+// summing up the operations and pushes it once. Rolling it back puts the
+// files it touched back as they were when it began, so a failed batch
+// writes nothing.
+const (
+	BATCH_COMMIT_TITLE = "Apply batch"
+)
+
+type transaction struct {
+	// paths to stage and messages of the operations, in order
+	paths    []string
+	messages []string
+	count    int
+	// dirty is the content of the files already changed when the
+	// transaction began, put back by a rollback
+	dirty map[string][]byte
+}
+
+func (tx *transaction) stage(paths []string, message string) {
+	tx.paths = append(tx.paths, paths...)
+	tx.count++
+	for _, known := range tx.messages {
+		if known == message {
+			return
+		}
+	}
+	tx.messages = append(tx.messages, message)
+}
+
+// changedFiles returns the repository relative paths of the worktree that
+// differ from HEAD.
+func changedFiles(worktree *git.Worktree) (map[string]bool, error) {
+	status, err := worktree.Status()
+	if err != nil {
+		HandleErr(err, "Couldn't get the worktree status")
+		return nil, err
+	}
+	changed := map[string]bool{}
+	for path, file := range status {
+		if file.Worktree != git.Unmodified || file.Staging != git.Unmodified {
+			changed[path] = true
+		}
+	}
+	return changed, nil
+}
+
+// Begin opens a transaction: StoreCommit stages instead of committing until
+// CommitTransaction or Rollback.
+func (keys *Keyring) Begin() error {
+	if keys.tx != nil {
+		return errors.New("a transaction is already open")
+	}
+	dirty, err := DirtyFiles()
+	if err != nil {
+		return err
+	}
+	keys.tx = &transaction{dirty: dirty}
+	return nil
+}
+
+// CommitTransaction commits everything staged since Begin in a single commit
+// and pushes it.
+func (keys *Keyring) CommitTransaction() error {
+	tx := keys.tx
+	if tx == nil {
+		return errors.New("no open transaction")
+	}
+	keys.tx = nil
+	if tx.count == 0 {
+		fmt.Println("Nothing to commit")
+		return nil
+	}
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return err
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		HandleErr(err, "Couldn't get the repository head")
+		return err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	// skip the files created then removed within the transaction
+	paths := []string{}
+	seen := map[string]bool{}
+	for _, path := range tx.paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		exists, err := PathExists(path)
+		if err != nil {
+			return err
+		}
+		relpath, err := filepath.Rel(repoPath, path)
+		if err != nil {
+			return err
+		}
+		if _, tracked := fileHash(commit, filepath.ToSlash(relpath)); exists || tracked {
+			paths = append(paths, path)
+		}
+	}
+	message := fmt.Sprintf("%s of %d changes\n\n%s", BATCH_COMMIT_TITLE, tx.count, strings.Join(tx.messages, "\n"))
+	return StoreCommit(paths, message, keys)
+}
+
+// Rollback ends the transaction and puts back the files changed since Begin
+// as they were then.
+func (keys *Keyring) Rollback() error {
+	tx := keys.tx
+	if tx == nil {
+		return errors.New("no open transaction")
+	}
+	keys.tx = nil
+	// the index and the store key are read again from the restored files
+	keys.index = nil
+	keys.storeKey = nil
+	return RestoreFromHead(tx.dirty)
+}
+
+// DirtyFiles returns the content of the files of the store worktree that
+// differ from HEAD by repository relative path, nil for removed ones.
+func DirtyFiles() (map[string][]byte, error) {
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		HandleErr(err, "Couldn't get worktree")
+		return nil, err
+	}
+	changed, err := changedFiles(worktree)
+	if err != nil {
+		return nil, err
+	}
+	dirty := map[string][]byte{}
+	for relpath := range changed {
+		b, err := ioutil.ReadFile(filepath.Join(repoPath, filepath.FromSlash(relpath)))
+		if err != nil && !os.IsNotExist(err) {
+			HandleErr(err, fmt.Sprintf("Couldn't read %v", relpath))
+			return nil, err
+		}
+		dirty[relpath] = b
+	}
+	return dirty, nil
+}
+
+// RestoreFromHead puts the files of the worktree changed since dirty was
+// taken back as they are at HEAD, and the files in dirty back as they were
+// then.
+func RestoreFromHead(dirty map[string][]byte) error {
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return err
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		HandleErr(err, "Couldn't get worktree")
+		return err
+	}
+	changed, err := changedFiles(worktree)
+	if err != nil {
+		return err
+	}
+	// before the first commit every file was created since
+	var commit *object.Commit
+	head, err := repo.Head()
+	if err == nil {
+		commit, err = repo.CommitObject(head.Hash())
+	}
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		HandleErr(err, "Couldn't get the repository head")
+		return err
+	}
+	for relpath := range dirty {
+		changed[relpath] = true
+	}
+	for relpath := range changed {
+		path := filepath.Join(repoPath, filepath.FromSlash(relpath))
+		b, wasDirty := dirty[relpath]
+		if !wasDirty && commit != nil {
+			b, err = blobAt(commit, relpath)
+			if err != nil {
+				return err
+			}
+		}
+		if b == nil {
+			// created since, or removed before
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				HandleErr(err, fmt.Sprintf("Couldn't remove %v", relpath))
+				return err
+			}
+			// and the directories created for it, os.Remove fails on the
+			// first one that isn't empty
+			for dir := filepath.Dir(path); dir != repoPath && os.Remove(dir) == nil; dir = filepath.Dir(dir) {
+			}
+			continue
+		}
+		err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path, b, 0644)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't restore %v", relpath))
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackFiles puts back the files written by an aborted operation as they
+// were before it and returns err.
+func rollbackFiles(dirty map[string][]byte, keys *Keyring, err error) error {
+	keys.index = nil
+	keys.storeKey = nil
+	restoreErr := RestoreFromHead(dirty)
+	if restoreErr != nil {
+		HandleErr(restoreErr, "Couldn't restore the store files, check vstore status")
+	}
+	return err
+}
+
+// splitScriptLine splits a batch script line in fields like a shell would:
+// on spaces, keeping single and double quoted strings together. Backslash
+// escapes the next character outside single quotes. A field starting with #
+// ends the line.
+func splitScriptLine(line string) ([]string, error) {
+	fields := []string{}
+	var field strings.Builder
+	inField := false
+	quote := rune(0)
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			field.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inField = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inField = true
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		case c == '#' && !inField:
+			return fields, nil
+		default:
+			field.WriteRune(c)
+			inField = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// batchStep runs the operation of a batch script line. Paths are store
+// relative and taken as they are, there is no fuzzy matching in scripts.
+func batchStep(fields []string, keys *Keyring) error {
+	storepath, err := GetStorePath()
+	if err != nil {
+		return err
+	}
+	if len(fields) < 2 {
+		return fmt.Errorf("unknown operation %v", strings.Join(fields, " "))
+	}
+	path := filepath.Join(storepath, fields[1])
+	switch {
+	case fields[0] == "set" && len(fields) >= 3 && len(fields) <= 5:
+		kind, input := VALUE_STRING, ""
+		args := fields[3:]
+		if len(args) > 0 && IsValueType(args[0]) {
+			kind, args = args[0], args[1:]
+		}
+		switch {
+		case kind == VALUE_NULL && len(args) == 0:
+		case len(args) == 1 && args[0] == "-g" && kind == VALUE_STRING:
+			input, err = GeneratePassword()
+			if err != nil {
+				return err
+			}
+		case len(args) == 1:
+			input = args[0]
+		default:
+			return errors.New("set takes a path, a pointer and a value")
+		}
+		value, err := ParseValue(kind, input)
+		if err != nil {
+			return err
+		}
+		return StoreSetValue(path, fields[2], value, keys)
+	case fields[0] == "unset" && len(fields) == 3:
+		return StoreUnsetValue(path, fields[2], keys)
+	case fields[0] == "mvkey" && len(fields) == 4:
+		return StoreMoveValue(path, fields[2], fields[3], keys)
+	case fields[0] == "cpkey" && len(fields) == 5:
+		return StoreCopyValue(path, fields[2], filepath.Join(storepath, fields[3]), fields[4], keys)
+	case fields[0] == "remove" && len(fields) == 2:
+		return StoreRemoveObject(path, keys)
+	case fields[0] == "mv" && len(fields) == 3:
+		return StoreMoveObject(path, fields[2], keys)
+	case fields[0] == "cp" && len(fields) == 3:
+		return StoreCopyObject(path, fields[2], keys)
+	}
+	return fmt.Errorf("unknown operation %v", strings.Join(fields, " "))
+}
+
+// RunBatch runs the operations of a script, one per line, in a single
+// transaction. Nothing is written unless every line succeeds.
+func RunBatch(script io.Reader, keys *Keyring) error {
+	err := keys.Begin()
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(script)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		fields, err := splitScriptLine(scanner.Text())
+		if err == nil && len(fields) > 0 {
+			err = batchStep(fields, keys)
+		}
+		if err != nil {
+			rollbackErr := keys.Rollback()
+			if rollbackErr != nil {
+				HandleErr(rollbackErr, "Couldn't roll back the batch, check vstore status")
+			}
+			return fmt.Errorf("line %d: %v, nothing was written", lineNumber, err)
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		keys.Rollback()
+		HandleErr(err, "Couldn't read the batch script")
+		return err
+	}
+	return keys.CommitTransaction()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitScriptLine(t *testing.T) {
+	cases := map[string][]string{
+		"set a/b /port --number 8080":         {"set", "a/b", "/port", "--number", "8080"},
+		`set "my dir/file" /note 'it''s'`:     {"set", "my dir/file", "/note", "its"},
+		`set a/b /json --json '{"k": "v w"}'`: {"set", "a/b", "/json", "--json", `{"k": "v w"}`},
+		`set a/b /empty ""`:                   {"set", "a/b", "/empty", ""},
+		`set a/b /x hash\#tag # comment`:      {"set", "a/b", "/x", "hash#tag"},
+		"  # only a comment":                  {},
+		"":                                    {},
+	}
+	for line, expected := range cases {
+		fields, err := splitScriptLine(line)
+		if err != nil {
+			t.Errorf("Couldn't split %q: %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(fields, expected) {
+			t.Errorf("Split %q into %q, expected %q", line, fields, expected)
+		}
+	}
+	_, err := splitScriptLine(`set a/b /x "unterminated`)
+	if err == nil {
+		t.Error("Expected an error for an unterminated quote")
+	}
+}
+
+func TestTransactionStage(t *testing.T) {
+	tx := &transaction{}
+	tx.stage([]string{"a"}, "Update content")
+	tx.stage([]string{"b"}, "Update content")
+	tx.stage([]string{"c"}, "Remove content")
+	if !reflect.DeepEqual(tx.paths, []string{"a", "b", "c"}) {
+		t.Errorf("Unexpected paths %v", tx.paths)
+	}
+	if tx.count != 3 || !reflect.DeepEqual(tx.messages, []string{"Update content", "Remove content"}) {
+		t.Errorf("Unexpected messages %v", tx.messages)
+	}
+}
+
+func TestRunBatchRollback(t *testing.T) {
+	storepath := testStore(t)
+	keys := testKeyring(t)
+	notes := filepath.Join(storepath, "notes")
+	err := StoreSetValue(notes, "/a", "1", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RunBatch(strings.NewReader("set notes /a 2\nset todo /b 3\nunset missing /c\n"), keys)
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Fatal("Expecting the batch to fail on line 3, got", err)
+	}
+	dirty, err := DirtyFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 0 {
+		t.Error("Expecting the worktree to match HEAD after a failed batch, got", dirty)
+	}
+	// changes made before the batch are kept
+	err = WriteRawJsonContent(notes, []byte(`{"a":"local"}`), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RunBatch(strings.NewReader("set notes /a 2\nunset missing /c\n"), keys)
+	if err == nil {
+		t.Fatal("Expecting the batch to fail")
+	}
+	doc, err := GetJsonContent(notes, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["a"] != "local" {
+		t.Error("Expecting the change made before the batch to be put back, got", doc)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// configentry reads and writes one of the settings exposed by `vstore config`.
+type configentry struct {
+	get func(settings *usersettings) string
+	set func(settings *usersettings, value string) error
+}
+
+var configEntries = map[string]configentry{
+	"format": {
+		get: func(settings *usersettings) string {
+			format, _ := ParseFormat(settings.Format)
+			return format
+		},
+		set: func(settings *usersettings, value string) error {
+			_, err := ParseFormat(value)
+			settings.Format = value
+			return err
+		},
+	},
+	"author-name": {
+		get: func(settings *usersettings) string {
+			if settings.AuthorName == "" {
+				return AUTHOR_NAME
+			}
+			return settings.AuthorName
+		},
+		set: func(settings *usersettings, value string) error {
+			settings.AuthorName = value
+			return nil
+		},
+	},
+	"author-email": {
+		get: func(settings *usersettings) string {
+			return settings.AuthorEmail
+		},
+		set: func(settings *usersettings, value string) error {
+			settings.AuthorEmail = value
+			return nil
+		},
+	},
+	"message-template": {
+		get: func(settings *usersettings) string {
+			return settings.MessageTemplate
+		},
+		set: func(settings *usersettings, value string) error {
+			settings.MessageTemplate = value
+			return nil
+		},
+	},
+	// comma separated path patterns, empty to redact nothing
+	"redact": {
+		get: func(settings *usersettings) string {
+			return strings.Join(settings.Redact, ",")
+		},
+		set: func(settings *usersettings, value string) error {
+			settings.Redact = nil
+			for _, pattern := range strings.Split(value, ",") {
+				pattern = strings.TrimSpace(pattern)
+				if pattern != "" {
+					settings.Redact = append(settings.Redact, pattern)
+				}
+			}
+			return nil
+		},
+	},
+	"padding": {
+		get: func(settings *usersettings) string {
+			if settings.Padding == "" {
+				return "padme"
+			}
+			return settings.Padding
+		},
+		set: func(settings *usersettings, value string) error {
+			_, err := ParsePadding(value)
+			settings.Padding = value
+			return err
+		},
+	},
+}
+
+// Config prints the setting called name, or changes it to value and saves the
+// settings when a value is given.
+func Config(password string, settings usersettings, name string, value *string) error {
+	entry, ok := configEntries[name]
+	if !ok {
+		names := []string{}
+		for name := range configEntries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown setting %v, known settings: %v", name, names)
+	}
+	if value == nil {
+		fmt.Println(entry.get(&settings))
+		return nil
+	}
+	err := entry.set(&settings, *value)
+	if err != nil {
+		return err
+	}
+	return CreateEncodedSettingsFile(password, settings)
+}
@@ -3,10 +3,14 @@ package main
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/sha512"
 	"errors"
 	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/pbkdf2"
 	"io"
 )
@@ -14,10 +18,56 @@ import (
 const (
 	PW_SALT_BYTES = 32
 	PW_KEY_BYTES  = 32
+
+	KEY_CHECK_BYTES = 8
+
+	LEGACY_PBKDF2_ITERATIONS = 4096
+
+	ARGON2_TIME        = 3
+	ARGON2_MEMORY      = 64 * 1024
+	ARGON2_PARALLELISM = 4
+
+	// highest costs read from an envelope header, above which a crafted file
+	// could exhaust the memory or the time of whoever opens it
+	PBKDF2_MAX_ITERATIONS  = 1000000
+	ARGON2_MAX_TIME        = 16
+	ARGON2_MAX_MEMORY      = KDF_BENCH_MAX_MEMORY
+	ARGON2_MAX_PARALLELISM = 16
 )
 
 func MakeKey(password []byte, salt [PW_SALT_BYTES]byte) [PW_KEY_BYTES]byte {
-	dk := pbkdf2.Key(password, salt[:], 4096, PW_KEY_BYTES, sha512.New)
+	return MakeKeyIter(password, salt, LEGACY_PBKDF2_ITERATIONS)
+}
+
+func MakeKeyIter(password []byte, salt [PW_SALT_BYTES]byte, iterations int) [PW_KEY_BYTES]byte {
+	dk := pbkdf2.Key(password, salt[:], iterations, PW_KEY_BYTES, sha512.New)
+	var arr [32]byte
+	copy(arr[:], dk)
+	return arr
+}
+
+// DeriveDataKey derives the key of a single content file from the store key
+// and the file salt with HKDF-SHA256.
+func DeriveDataKey(storeKey *[STORE_KEY_BYTES]byte, salt [PW_SALT_BYTES]byte) ([PW_KEY_BYTES]byte, error) {
+	var key [PW_KEY_BYTES]byte
+	_, err := io.ReadFull(hkdf.New(sha256.New, storeKey[:], salt[:], []byte("vstore data key")), key[:])
+	if err != nil {
+		HandleErr(err, "Couldn't derive data key")
+	}
+	return key, err
+}
+
+// MakeKeyCheck returns a short value identifying key without revealing it.
+func MakeKeyCheck(key *[PW_KEY_BYTES]byte) [KEY_CHECK_BYTES]byte {
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("vstore key check"))
+	var check [KEY_CHECK_BYTES]byte
+	copy(check[:], mac.Sum(nil))
+	return check
+}
+
+func MakeKeyArgon2id(password []byte, salt [PW_SALT_BYTES]byte, time uint32, memory uint32, parallelism uint8) [PW_KEY_BYTES]byte {
+	dk := argon2.IDKey(password, salt[:], time, memory, parallelism, PW_KEY_BYTES)
 	var arr [32]byte
 	copy(arr[:], dk)
 	return arr
@@ -26,8 +76,9 @@ func MakeKey(password []byte, salt [PW_SALT_BYTES]byte) [PW_KEY_BYTES]byte {
 // https://github.com/gtank/cryptopasta/blob/master/encrypt.go
 // Decrypt decrypts data using 256-bit AES-GCM.  This both hides the content of
 // the data and provides a check that it hasn't been altered. Expects input
-// form nonce|ciphertext|tag where '|' indicates concatenation.
-func Decrypt(ciphertext []byte, key *[PW_KEY_BYTES]byte) (plaintext []byte, err error) {
+// form nonce|ciphertext|tag where '|' indicates concatenation. additionalData
+// must be the same as the one given to Encrypt.
+func Decrypt(ciphertext []byte, key *[PW_KEY_BYTES]byte, additionalData []byte) (plaintext []byte, err error) {
 	block, err := aes.NewCipher(key[:])
 	if err != nil {
 		HandleErr(err, fmt.Sprintf("Couldn't create cipher with key %v", key))
@@ -47,7 +98,7 @@ func Decrypt(ciphertext []byte, key *[PW_KEY_BYTES]byte) (plaintext []byte, err
 	return gcm.Open(nil,
 		ciphertext[:gcm.NonceSize()],
 		ciphertext[gcm.NonceSize():],
-		nil,
+		additionalData,
 	)
 }
 
@@ -55,8 +106,9 @@ func Decrypt(ciphertext []byte, key *[PW_KEY_BYTES]byte) (plaintext []byte, err
 // Encrypt encrypts data using 256-bit AES-GCM.  This both hides the content of
 // Decrypt decrypts data using 256-bit AES-GCM.  This both hides the content of
 // the data and provides a check that it hasn't been altered. Output takes the
-// form nonce|ciphertext|tag where '|' indicates concatenation.
-func Encrypt(plaintext []byte, key *[PW_KEY_BYTES]byte) (ciphertext []byte, err error) {
+// form nonce|ciphertext|tag where '|' indicates concatenation. additionalData
+// is authenticated but not encrypted.
+func Encrypt(plaintext []byte, key *[PW_KEY_BYTES]byte, additionalData []byte) (ciphertext []byte, err error) {
 	block, err := aes.NewCipher(key[:])
 	if err != nil {
 		HandleErr(err, fmt.Sprintf("Couldn't create cipher from key %v", key))
@@ -76,7 +128,7 @@ func Encrypt(plaintext []byte, key *[PW_KEY_BYTES]byte) (ciphertext []byte, err
 		return nil, err
 	}
 
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
 }
 func GenerateSalt() ([PW_SALT_BYTES]byte, error) {
 	salt := make([]byte, PW_SALT_BYTES)
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Diffs compare decrypted JSON documents pointer by pointer. Values are
+// masked unless revealed. A missing revision stands for the current content
+// of the store.
+const (
+	DIFF_ADDED   = "+"
+	DIFF_REMOVED = "-"
+	DIFF_CHANGED = "~"
+	// the default first revision when nothing was pulled yet
+	DIFF_DEFAULT_REV = "HEAD~1"
+)
+
+type diffentry struct {
+	Op      string
+	Pointer string
+	Old     interface{}
+	New     interface{}
+}
+
+func escapePointerToken(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+// JsonDiff returns the pointers added, removed and changed from a to b,
+// sorted by pointer. Objects and arrays are compared member by member.
+func JsonDiff(a interface{}, b interface{}) []diffentry {
+	entries := []diffentry{}
+	jsonDiff("", a, b, &entries)
+	return entries
+}
+
+func jsonDiff(pointer string, a interface{}, b interface{}, entries *[]diffentry) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			keys := []string{}
+			for key := range av {
+				keys = append(keys, key)
+			}
+			for key := range bv {
+				if _, ok := av[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				child := pointer + "/" + escapePointerToken(key)
+				old, inA := av[key]
+				new, inB := bv[key]
+				switch {
+				case !inA:
+					*entries = append(*entries, diffentry{Op: DIFF_ADDED, Pointer: child, New: new})
+				case !inB:
+					*entries = append(*entries, diffentry{Op: DIFF_REMOVED, Pointer: child, Old: old})
+				default:
+					jsonDiff(child, old, new, entries)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				child := pointer + "/" + strconv.Itoa(i)
+				switch {
+				case i >= len(av):
+					*entries = append(*entries, diffentry{Op: DIFF_ADDED, Pointer: child, New: bv[i]})
+				case i >= len(bv):
+					*entries = append(*entries, diffentry{Op: DIFF_REMOVED, Pointer: child, Old: av[i]})
+				default:
+					jsonDiff(child, av[i], bv[i], entries)
+				}
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*entries = append(*entries, diffentry{Op: DIFF_CHANGED, Pointer: pointer, Old: a, New: b})
+	}
+}
+
+func formatDiffValue(value interface{}) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "?"
+	}
+	return string(b)
+}
+
+// FormatDiff returns one line per entry, with the values when reveal is set.
+func FormatDiff(entries []diffentry, reveal bool) []string {
+	lines := []string{}
+	for _, entry := range entries {
+		pointer := entry.Pointer
+		if pointer == "" {
+			pointer = "/"
+		}
+		line := fmt.Sprintf("%s %s", entry.Op, pointer)
+		if reveal {
+			switch entry.Op {
+			case DIFF_ADDED:
+				line += ": " + formatDiffValue(entry.New)
+			case DIFF_REMOVED:
+				line += ": " + formatDiffValue(entry.Old)
+			case DIFF_CHANGED:
+				line += fmt.Sprintf(": %s -> %s", formatDiffValue(entry.Old), formatDiffValue(entry.New))
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// DefaultDiffRev returns the head before the last pull that brought changes,
+// or the previous commit.
+func DefaultDiffRev(repo *git.Repository) string {
+	_, err := repo.Reference(ORIG_HEAD, true)
+	if err == nil {
+		return ORIG_HEAD.String()
+	}
+	return DIFF_DEFAULT_REV
+}
+
+// decodeDocument parses rawjson, nil stands for a missing object.
+func decodeDocument(rawjson []byte) (interface{}, error) {
+	if rawjson == nil {
+		return nil, nil
+	}
+	var document interface{}
+	err := UnmarshalJson(rawjson, &document)
+	if err != nil {
+		HandleErr(err, "Couldn't read content file as JSON object")
+	}
+	return document, err
+}
+
+// objectContentAt decrypts the object at the logical path at rev, the current
+// content when rev is empty. It returns nil when there is no such object.
+func objectContentAt(path string, rev string, keys *Keyring) ([]byte, error) {
+	if rev == "" {
+		exists, err := ObjectExists(path, keys)
+		if err != nil || !exists {
+			return nil, err
+		}
+		return GetRawJsonContent(path, keys)
+	}
+	rawjson, err := GetRawJsonContentAt(path, rev, keys)
+	if err == ErrNoContent {
+		return nil, nil
+	}
+	return rawjson, err
+}
+
+// storeContentsAt decrypts every object of the store at rev, the current
+// content when rev is empty, by location. Objects not shared with the user
+// are added to skipped rather than read.
+func storeContentsAt(repo *git.Repository, rev string, keys *Keyring, skipped map[string]bool) (map[string][]byte, error) {
+	contents := map[string][]byte{}
+	if rev == "" {
+		files, err := ListObjects(keys)
+		if err != nil {
+			return nil, err
+		}
+		storepath, err := GetStorePath()
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			rawjson, err := GetRawJsonContent(filepath.Join(storepath, file), keys)
+			if errors.Is(err, ErrNotRecipient) {
+				skipped[filepath.ToSlash(file)] = true
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			contents[filepath.ToSlash(file)] = rawjson
+		}
+		return contents, nil
+	}
+	commit, err := ResolveAt(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexAt(commit, keys)
+	if err != nil {
+		return nil, err
+	}
+	files, err := commit.Files()
+	if err != nil {
+		return nil, err
+	}
+	err = files.ForEach(func(file *object.File) error {
+		if !strings.HasPrefix(file.Name, STORE_FOLDER_NAME+"/") || strings.HasSuffix(file.Name, "/"+RECIPIENTS_FILE) {
+			return nil
+		}
+		rawjson, err := openAt(commit, file.Name, index, keys)
+		if errors.Is(err, ErrNotRecipient) {
+			skipped[locationAt(file.Name, index)] = true
+			return nil
+		}
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't decrypt %v at %v", file.Name, rev))
+			return err
+		}
+		contents[locationAt(file.Name, index)] = rawjson
+		return nil
+	})
+	return contents, err
+}
+
+func printDocumentDiff(header string, a []byte, b []byte, reveal bool) error {
+	if a == nil && b == nil {
+		return nil
+	}
+	before, err := decodeDocument(a)
+	if err != nil {
+		return err
+	}
+	after, err := decodeDocument(b)
+	if err != nil {
+		return err
+	}
+	entries := JsonDiff(before, after)
+	if before == nil || after == nil {
+		// the whole object was added or removed, list its members
+		empty := map[string]interface{}{}
+		if before == nil {
+			entries = JsonDiff(empty, after)
+		} else {
+			entries = JsonDiff(before, empty)
+		}
+	}
+	if len(entries) == 0 && a != nil && b != nil {
+		return nil
+	}
+	status := "changed"
+	if a == nil {
+		status = "added"
+	} else if b == nil {
+		status = "removed"
+	}
+	fmt.Printf("%s (%s)\n", header, status)
+	for _, line := range FormatDiff(entries, reveal) {
+		fmt.Println("  " + line)
+	}
+	return nil
+}
+
+// PrintDiff prints the structural diff of the object at the logical path, or
+// of the whole store when path is empty, from rev1 to rev2. An empty rev1
+// defaults to DefaultDiffRev, an empty rev2 to the current content.
+func PrintDiff(path string, rev1 string, rev2 string, reveal bool, keys *Keyring) error {
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	if rev1 == "" {
+		rev1 = DefaultDiffRev(repo)
+	}
+	if path != "" {
+		a, err := objectContentAt(path, rev1, keys)
+		if err != nil {
+			return err
+		}
+		b, err := objectContentAt(path, rev2, keys)
+		if err != nil {
+			return err
+		}
+		location, err := ObjectLocation(path)
+		if err != nil {
+			return err
+		}
+		return printDocumentDiff(location, a, b, reveal)
+	}
+	skipped := map[string]bool{}
+	before, err := storeContentsAt(repo, rev1, keys, skipped)
+	if err != nil {
+		return err
+	}
+	after, err := storeContentsAt(repo, rev2, keys, skipped)
+	if err != nil {
+		return err
+	}
+	locations := []string{}
+	for location := range before {
+		locations = append(locations, location)
+	}
+	for location := range after {
+		if _, ok := before[location]; !ok {
+			locations = append(locations, location)
+		}
+	}
+	sort.Strings(locations)
+	for _, location := range locations {
+		if skipped[location] {
+			continue
+		}
+		err = printDocumentDiff(location, before[location], after[location], reveal)
+		if err != nil {
+			return err
+		}
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("%d objects not readable with your identity were skipped\n", len(skipped))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJsonDiff(t *testing.T) {
+	a := decodeJson(t, `{"login": "john", "password": "a", "old": 1, "tags": ["x"], "nested": {"a/b": true}}`)
+	b := decodeJson(t, `{"login": "john", "password": "b", "port": 8080, "tags": ["x", "y"], "nested": {"a/b": false}}`)
+	lines := FormatDiff(JsonDiff(a, b), false)
+	expected := []string{"~ /nested/a~1b", "- /old", "~ /password", "+ /port", "+ /tags/1"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Error("Expecting", expected, "got", lines)
+	}
+	revealed := FormatDiff(JsonDiff(a, b), true)
+	if revealed[2] != `~ /password: "a" -> "b"` || revealed[3] != "+ /port: 8080" {
+		t.Error("Expecting revealed values, got", revealed)
+	}
+	if len(JsonDiff(a, a)) != 0 {
+		t.Error("Expecting no difference between identical documents")
+	}
+}
+
+func TestStoreContentsSkipsUnreadable(t *testing.T) {
+	storepath := testStore(t)
+	// without an identity, as before running vstore identity
+	keys := testKeyring(t)
+	_, bob := testRecipient(t, "bob")
+	approve := RecipientApprover
+	defer func() { RecipientApprover = approve }()
+	RecipientApprover = func(string, recipient) bool { return true }
+	_, err := writeRecipientsFile("team", []recipient{bob}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, location := range []string{"notes", "team/wifi"} {
+		err = WriteRawJsonContent(filepath.Join(storepath, location), []byte(`{"a":1}`), keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := map[string]bool{}
+	contents, err := storeContentsAt(repo, "", keys, skipped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 1 || contents["notes"] == nil || !skipped["team/wifi"] {
+		t.Error("Expecting the object not shared with the user to be skipped, got", contents, skipped)
+	}
+}
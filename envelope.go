@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// On-disk envelope layout:
+//
+//	magic | version | kdf id | kdf params length | kdf params | cipher id | padding id | key check | payload
+//
+// The payload is whatever the cipher produces, nonce|ciphertext|tag for
+// AES-GCM, with the header and the file location as associated data. The
+// content is padded before encryption as told by the padding id. Version 2
+// has no padding id, version 1 has no key check and no associated data. Files written before the
+// envelope existed are salt|payload with PBKDF2-SHA512 and 4096 iterations,
+// they are read as version 0.
+const (
+	ENVELOPE_MAGIC     = "VST\x00"
+	ENVELOPE_VERSION_0 = 0
+	ENVELOPE_VERSION_1 = 1
+	ENVELOPE_VERSION_2 = 2
+	ENVELOPE_VERSION_3 = 3
+
+	KDF_PBKDF2_SHA512 = 1
+	KDF_ARGON2ID      = 2
+	// data key derived from the store key, see Keyring
+	KDF_HKDF_STORE_KEY = 3
+	// file key wrapped for a list of recipients, see SealForRecipients
+	KDF_X25519_RECIPIENTS = 4
+
+	CIPHER_AES256_GCM = 1
+)
+
+type KdfParams struct {
+	Id byte
+	// Iterations is the PBKDF2 iteration count or the Argon2 time cost.
+	Iterations uint32
+	// Memory is the Argon2 memory cost in KiB.
+	Memory      uint32
+	Parallelism uint8
+	Salt        [PW_SALT_BYTES]byte
+	// Stanzas hold the file key wrapped for each recipient.
+	Stanzas []RecipientStanza
+}
+
+// KeySource returns the key of an envelope sealed with the given kdf
+// parameters.
+type KeySource func(kdf KdfParams) ([PW_KEY_BYTES]byte, error)
+
+type envelopeHeader struct {
+	Version byte
+	Kdf     KdfParams
+	Cipher  byte
+	Padding byte
+	// KeyCheck tells a wrong key apart from a relocated or altered payload.
+	KeyCheck [KEY_CHECK_BYTES]byte
+}
+
+var (
+	ErrWrongKey        = errors.New("wrong key: the password or master key doesn't match")
+	ErrObjectRelocated = errors.New("object relocated: the content was sealed for another path or was altered")
+	ErrWrongSealing    = errors.New("wrong sealing: the content isn't sealed the way its location requires, it may be forged")
+)
+
+// NewKdfParams returns the parameters used for newly written files, with a
+// fresh salt. Argon2id is used with the costs from settings, or the defaults
+// when settings is nil.
+func NewKdfParams(settings *kdfsettings) (KdfParams, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return KdfParams{}, err
+	}
+	kdf := KdfParams{
+		Id:          KDF_ARGON2ID,
+		Iterations:  ARGON2_TIME,
+		Memory:      ARGON2_MEMORY,
+		Parallelism: ARGON2_PARALLELISM,
+		Salt:        salt,
+	}
+	if settings != nil {
+		kdf.Iterations = settings.Time
+		kdf.Memory = settings.Memory
+		kdf.Parallelism = settings.Parallelism
+	}
+	return kdf, nil
+}
+
+// SameKdfCost tells whether a and b derive keys with the same algorithm and
+// costs, regardless of salt.
+func SameKdfCost(a KdfParams, b KdfParams) bool {
+	return a.Id == b.Id && a.Iterations == b.Iterations && a.Memory == b.Memory && a.Parallelism == b.Parallelism
+}
+
+// PasswordKey returns a KeySource stretching password with the password
+// based key derivation functions.
+func PasswordKey(password string) KeySource {
+	return func(kdf KdfParams) ([PW_KEY_BYTES]byte, error) {
+		return DeriveKey([]byte(password), kdf)
+	}
+}
+
+func DeriveKey(password []byte, kdf KdfParams) ([PW_KEY_BYTES]byte, error) {
+	switch kdf.Id {
+	case KDF_PBKDF2_SHA512:
+		if kdf.Iterations == 0 || kdf.Iterations > PBKDF2_MAX_ITERATIONS {
+			return [PW_KEY_BYTES]byte{}, fmt.Errorf("invalid pbkdf2 iterations %d", kdf.Iterations)
+		}
+		return MakeKeyIter(password, kdf.Salt, int(kdf.Iterations)), nil
+	case KDF_ARGON2ID:
+		if kdf.Iterations == 0 || kdf.Memory == 0 || kdf.Parallelism == 0 {
+			return [PW_KEY_BYTES]byte{}, errors.New("invalid argon2id parameters")
+		}
+		if kdf.Iterations > ARGON2_MAX_TIME || kdf.Memory > ARGON2_MAX_MEMORY || kdf.Parallelism > ARGON2_MAX_PARALLELISM {
+			return [PW_KEY_BYTES]byte{}, fmt.Errorf("argon2id costs time=%d memory=%dKiB parallelism=%d are above the limits", kdf.Iterations, kdf.Memory, kdf.Parallelism)
+		}
+		return MakeKeyArgon2id(password, kdf.Salt, kdf.Iterations, kdf.Memory, kdf.Parallelism), nil
+	}
+	return [PW_KEY_BYTES]byte{}, fmt.Errorf("unknown kdf id %d", kdf.Id)
+}
+
+func encodeKdfParams(kdf KdfParams) ([]byte, error) {
+	var buf bytes.Buffer
+	switch kdf.Id {
+	case KDF_PBKDF2_SHA512:
+		binary.Write(&buf, binary.BigEndian, kdf.Iterations)
+		buf.Write(kdf.Salt[:])
+	case KDF_ARGON2ID:
+		binary.Write(&buf, binary.BigEndian, kdf.Iterations)
+		binary.Write(&buf, binary.BigEndian, kdf.Memory)
+		buf.WriteByte(kdf.Parallelism)
+		buf.Write(kdf.Salt[:])
+	case KDF_HKDF_STORE_KEY:
+		buf.Write(kdf.Salt[:])
+	case KDF_X25519_RECIPIENTS:
+		binary.Write(&buf, binary.BigEndian, uint16(len(kdf.Stanzas)))
+		for _, stanza := range kdf.Stanzas {
+			buf.Write(stanza.Ephemeral[:])
+			buf.Write(stanza.WrappedKey[:])
+		}
+	default:
+		return nil, fmt.Errorf("unknown kdf id %d", kdf.Id)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeKdfParams(id byte, b []byte) (KdfParams, error) {
+	kdf := KdfParams{Id: id}
+	switch id {
+	case KDF_PBKDF2_SHA512:
+		if len(b) != 4+PW_SALT_BYTES {
+			return KdfParams{}, errors.New("malformed pbkdf2 parameters")
+		}
+		kdf.Iterations = binary.BigEndian.Uint32(b[:4])
+		copy(kdf.Salt[:], b[4:])
+	case KDF_ARGON2ID:
+		if len(b) != 9+PW_SALT_BYTES {
+			return KdfParams{}, errors.New("malformed argon2id parameters")
+		}
+		kdf.Iterations = binary.BigEndian.Uint32(b[:4])
+		kdf.Memory = binary.BigEndian.Uint32(b[4:8])
+		kdf.Parallelism = b[8]
+		copy(kdf.Salt[:], b[9:])
+	case KDF_HKDF_STORE_KEY:
+		if len(b) != PW_SALT_BYTES {
+			return KdfParams{}, errors.New("malformed store key parameters")
+		}
+		copy(kdf.Salt[:], b)
+	case KDF_X25519_RECIPIENTS:
+		if len(b) < 2 {
+			return KdfParams{}, errors.New("malformed recipients parameters")
+		}
+		count := int(binary.BigEndian.Uint16(b[:2]))
+		b = b[2:]
+		stanzaLen := X25519_KEY_BYTES + WRAPPED_KEY_BYTES
+		if len(b) != count*stanzaLen {
+			return KdfParams{}, errors.New("malformed recipients parameters")
+		}
+		for i := 0; i < count; i++ {
+			var stanza RecipientStanza
+			copy(stanza.Ephemeral[:], b[:X25519_KEY_BYTES])
+			copy(stanza.WrappedKey[:], b[X25519_KEY_BYTES:stanzaLen])
+			kdf.Stanzas = append(kdf.Stanzas, stanza)
+			b = b[stanzaLen:]
+		}
+	default:
+		return KdfParams{}, fmt.Errorf("unknown kdf id %d", id)
+	}
+	return kdf, nil
+}
+
+func encodeEnvelopeHeader(header envelopeHeader) ([]byte, error) {
+	params, err := encodeKdfParams(header.Kdf)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(ENVELOPE_MAGIC)
+	buf.WriteByte(header.Version)
+	buf.WriteByte(header.Kdf.Id)
+	binary.Write(&buf, binary.BigEndian, uint16(len(params)))
+	buf.Write(params)
+	buf.WriteByte(header.Cipher)
+	if header.Version >= ENVELOPE_VERSION_3 {
+		buf.WriteByte(header.Padding)
+	}
+	if header.Version >= ENVELOPE_VERSION_2 {
+		buf.Write(header.KeyCheck[:])
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeEnvelopeHeader parses the header at the start of data and returns
+// it along with the remaining payload.
+func decodeEnvelopeHeader(data []byte) (envelopeHeader, []byte, error) {
+	if !bytes.HasPrefix(data, []byte(ENVELOPE_MAGIC)) {
+		// legacy salt|payload layout
+		if len(data) < PW_SALT_BYTES {
+			return envelopeHeader{}, nil, errors.New("malformed content file")
+		}
+		header := envelopeHeader{
+			Version: ENVELOPE_VERSION_0,
+			Kdf:     KdfParams{Id: KDF_PBKDF2_SHA512, Iterations: LEGACY_PBKDF2_ITERATIONS},
+			Cipher:  CIPHER_AES256_GCM,
+		}
+		copy(header.Kdf.Salt[:], data[:PW_SALT_BYTES])
+		return header, data[PW_SALT_BYTES:], nil
+	}
+	b := data[len(ENVELOPE_MAGIC):]
+	if len(b) < 4 {
+		return envelopeHeader{}, nil, errors.New("truncated envelope header")
+	}
+	header := envelopeHeader{Version: b[0]}
+	if header.Version < ENVELOPE_VERSION_1 || header.Version > ENVELOPE_VERSION_3 {
+		return envelopeHeader{}, nil, fmt.Errorf("unsupported envelope version %d", header.Version)
+	}
+	kdfId := b[1]
+	paramsLen := int(binary.BigEndian.Uint16(b[2:4]))
+	b = b[4:]
+	if len(b) < paramsLen+1 {
+		return envelopeHeader{}, nil, errors.New("truncated envelope header")
+	}
+	kdf, err := decodeKdfParams(kdfId, b[:paramsLen])
+	if err != nil {
+		return envelopeHeader{}, nil, err
+	}
+	header.Kdf = kdf
+	header.Cipher = b[paramsLen]
+	b = b[paramsLen+1:]
+	if header.Version >= ENVELOPE_VERSION_3 {
+		if len(b) < 1 {
+			return envelopeHeader{}, nil, errors.New("truncated envelope header")
+		}
+		header.Padding = b[0]
+		b = b[1:]
+	}
+	if header.Version >= ENVELOPE_VERSION_2 {
+		if len(b) < KEY_CHECK_BYTES {
+			return envelopeHeader{}, nil, errors.New("truncated envelope header")
+		}
+		copy(header.KeyCheck[:], b[:KEY_CHECK_BYTES])
+		b = b[KEY_CHECK_BYTES:]
+	}
+	return header, b, nil
+}
+
+// envelopeAdditionalData binds the payload to its header, so the format
+// version and kdf can't be downgraded, and to the location of the file.
+func envelopeAdditionalData(encodedHeader []byte, location string) []byte {
+	ad := append([]byte{}, encodedHeader...)
+	return append(ad, []byte(location)...)
+}
+
+// SealEnvelope pads plaintext, encrypts it with the key source gives for kdf
+// and prefixes the result with a header describing how to decrypt it.
+// location is authenticated along with the content: the envelope only opens
+// for the same location.
+func SealEnvelope(plaintext []byte, kdf KdfParams, source KeySource, location string, padding byte) ([]byte, error) {
+	padded, err := Pad(plaintext, padding)
+	if err != nil {
+		return nil, err
+	}
+	key, err := source(kdf)
+	if err != nil {
+		return nil, err
+	}
+	header := envelopeHeader{
+		Version:  ENVELOPE_VERSION_3,
+		Kdf:      kdf,
+		Cipher:   CIPHER_AES256_GCM,
+		Padding:  padding,
+		KeyCheck: MakeKeyCheck(&key),
+	}
+	encodedHeader, err := encodeEnvelopeHeader(header)
+	if err != nil {
+		HandleErr(err, "Couldn't encode envelope header")
+		return nil, err
+	}
+	encrypted, err := Encrypt(padded, &key, envelopeAdditionalData(encodedHeader, location))
+	if err != nil {
+		return nil, err
+	}
+	return append(encodedHeader, encrypted...), nil
+}
+
+// EnvelopeKdf returns the key derivation parameters data was sealed with.
+func EnvelopeKdf(data []byte) (KdfParams, error) {
+	header, _, err := decodeEnvelopeHeader(data)
+	return header.Kdf, err
+}
+
+// OpenEnvelope decrypts data written by SealEnvelope, or by any earlier
+// version of vstore. Envelopes sealed for another location fail with
+// ErrObjectRelocated, locations are only checked from version 2.
+func OpenEnvelope(data []byte, source KeySource, location string) ([]byte, error) {
+	header, payload, err := decodeEnvelopeHeader(data)
+	if err != nil {
+		HandleErr(err, "Couldn't read envelope header")
+		return nil, err
+	}
+	if header.Cipher != CIPHER_AES256_GCM {
+		return nil, fmt.Errorf("unknown cipher id %d", header.Cipher)
+	}
+	key, err := source(header.Kdf)
+	if err != nil {
+		return nil, err
+	}
+	if header.Version < ENVELOPE_VERSION_2 {
+		return Decrypt(payload, &key, nil)
+	}
+	check := MakeKeyCheck(&key)
+	if subtle.ConstantTimeCompare(check[:], header.KeyCheck[:]) != 1 {
+		return nil, ErrWrongKey
+	}
+	ad := envelopeAdditionalData(data[:len(data)-len(payload)], location)
+	padded, err := Decrypt(payload, &key, ad)
+	if err != nil {
+		// the key is right, the content was moved or altered
+		return nil, ErrObjectRelocated
+	}
+	return Unpad(padded, header.Padding)
+}
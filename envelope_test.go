@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSealOpenEnvelope(t *testing.T) {
+	kdf, err := NewKdfParams(&kdfsettings{Time: 1, Memory: 8 * 1024, Parallelism: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealEnvelope([]byte("{\"login\":\"john\"}"), kdf, PasswordKey("password"), "credentials/gmail", PADDING_PADME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sealed, []byte(ENVELOPE_MAGIC)) {
+		t.Error("Expecting sealed content to start with the envelope magic")
+	}
+	header, _, err := decodeEnvelopeHeader(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != ENVELOPE_VERSION_3 || header.Cipher != CIPHER_AES256_GCM || header.Padding != PADDING_PADME {
+		t.Error("Unexpected envelope header", header)
+	}
+	if !reflect.DeepEqual(header.Kdf, kdf) {
+		t.Error("Expecting kdf parameters to be stored in the header, got", header.Kdf)
+	}
+	plaintext, err := OpenEnvelope(sealed, PasswordKey("password"), "credentials/gmail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "{\"login\":\"john\"}" {
+		t.Error("Expecting plaintext to round trip, got", string(plaintext))
+	}
+	_, err = OpenEnvelope(sealed, PasswordKey("wrongpassword"), "credentials/gmail")
+	if err != ErrWrongKey {
+		t.Error("Expecting a wrong key error when opening with the wrong password, got", err)
+	}
+	_, err = OpenEnvelope(sealed, PasswordKey("password"), "credentials/bank")
+	if err != ErrObjectRelocated {
+		t.Error("Expecting a relocation error when opening at another location, got", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	_, err = OpenEnvelope(sealed, PasswordKey("password"), "credentials/gmail")
+	if err == nil {
+		t.Error("Expecting an error when opening altered content")
+	}
+}
+
+func TestOpenLegacyEnvelope(t *testing.T) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := MakeKey([]byte("password"), salt)
+	encrypted, err := Encrypt([]byte("legacy"), &key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := OpenEnvelope(append(salt[:], encrypted...), PasswordKey("password"), "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "legacy" {
+		t.Error("Expecting legacy content to be readable, got", string(plaintext))
+	}
+}
+
+func TestKdfParamsEncoding(t *testing.T) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kdf := range []KdfParams{
+		{Id: KDF_PBKDF2_SHA512, Iterations: 4096, Salt: salt},
+		{Id: KDF_ARGON2ID, Iterations: 3, Memory: 64 * 1024, Parallelism: 4, Salt: salt},
+	} {
+		b, err := encodeKdfParams(kdf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeKdfParams(kdf.Id, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, kdf) {
+			t.Error("Expecting kdf parameters to round trip, got", decoded, "want", kdf)
+		}
+	}
+}
+
+func TestDeriveKeyCostLimits(t *testing.T) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kdf := range []KdfParams{
+		{Id: KDF_PBKDF2_SHA512, Iterations: 0, Salt: salt},
+		{Id: KDF_PBKDF2_SHA512, Iterations: PBKDF2_MAX_ITERATIONS + 1, Salt: salt},
+		{Id: KDF_ARGON2ID, Iterations: ARGON2_MAX_TIME + 1, Memory: 8 * 1024, Parallelism: 1, Salt: salt},
+		{Id: KDF_ARGON2ID, Iterations: 1, Memory: ARGON2_MAX_MEMORY + 1, Parallelism: 1, Salt: salt},
+		{Id: KDF_ARGON2ID, Iterations: 1, Memory: 8 * 1024, Parallelism: ARGON2_MAX_PARALLELISM + 1, Salt: salt},
+	} {
+		_, err := DeriveKey([]byte("password"), kdf)
+		if err == nil {
+			t.Error("Expecting costs above the limits to be refused, got none for", kdf)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/samuel-soubeyran/gojsonpointer"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// History is read from the commits of the local repository. An object is
+// followed by the repository relative path of its content file, through the
+// rename trailers when asked to. Old versions are decrypted with the current
+// keys, versions sealed with an older master key itself can't be read.
+
+// AT_DATE_FORMATS are the date layouts accepted by get --at, in local time.
+var AT_DATE_FORMATS = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", time.RFC3339}
+
+// objectversion is a commit that changed the content file at Path.
+type objectversion struct {
+	Commit *object.Commit
+	Path   string
+}
+
+func OpenStoreRepo() (*git.Repository, error) {
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't open repo at path %v", repoPath))
+	}
+	return repo, err
+}
+
+// renamedFrom returns the path current was moved or copied from in the
+// commit message, or an empty string.
+func renamedFrom(message string, current string) string {
+	for _, line := range strings.Split(message, "\n") {
+		for _, kind := range []string{MOVED_TRAILER, COPIED_TRAILER} {
+			if !strings.HasPrefix(line, kind+" ") {
+				continue
+			}
+			fields := strings.SplitN(strings.TrimPrefix(line, kind+" "), " -> ", 2)
+			if len(fields) == 2 && strings.TrimSpace(fields[1]) == current {
+				return strings.TrimSpace(fields[0])
+			}
+		}
+	}
+	return ""
+}
+
+func fileHash(commit *object.Commit, path string) (plumbing.Hash, bool) {
+	file, err := commit.File(path)
+	if err != nil {
+		return plumbing.ZeroHash, false
+	}
+	return file.Hash, true
+}
+
+// walkObjectHistory calls fn with each commit reachable from HEAD, newest
+// first, the path of the content file in it and whether the commit changed
+// it. fn returns storer.ErrStop to end the walk.
+func walkObjectHistory(repo *git.Repository, current string, follow bool, fn func(commit *object.Commit, path string, changed bool) error) error {
+	head, err := repo.Head()
+	if err != nil {
+		HandleErr(err, "Couldn't get the repository head")
+		return err
+	}
+	commits, err := repo.Log(&git.LogOptions{From: head.Hash(), Order: git.LogOrderCommitterTime})
+	if err != nil {
+		HandleErr(err, "Couldn't read the repository log")
+		return err
+	}
+	return commits.ForEach(func(commit *object.Commit) error {
+		hash, ok := fileHash(commit, current)
+		changed := false
+		if ok {
+			changed = true
+			if commit.NumParents() > 0 {
+				parent, err := commit.Parent(0)
+				if err != nil {
+					return err
+				}
+				parentHash, parentOk := fileHash(parent, current)
+				changed = !parentOk || parentHash != hash
+			}
+		}
+		err := fn(commit, current, changed)
+		if err != nil {
+			return err
+		}
+		if follow {
+			from := renamedFrom(commit.Message, current)
+			if from != "" {
+				current = from
+			}
+		}
+		return nil
+	})
+}
+
+// contentRelPath returns the slash separated repository relative path of the
+// content file at the logical path.
+func contentRelPath(path string, keys *Keyring) (string, error) {
+	physical, err := PhysicalPath(path, keys, false)
+	if err != nil {
+		return "", err
+	}
+	relpath, err := RepoRelPath(physical)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relpath), nil
+}
+
+// ObjectHistory returns the commits that changed the object at the logical
+// path, newest first.
+func ObjectHistory(path string, follow bool, keys *Keyring) ([]objectversion, error) {
+	current, err := contentRelPath(path, keys)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return nil, err
+	}
+	versions := []objectversion{}
+	err = walkObjectHistory(repo, current, follow, func(commit *object.Commit, path string, changed bool) error {
+		if changed {
+			versions = append(versions, objectversion{Commit: commit, Path: path})
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// indexAt returns the object index committed in commit, nil when there is
+// none.
+func indexAt(commit *object.Commit, keys *Keyring) (*objectindex, error) {
+	file, err := commit.File(INDEX_LOCATION)
+	if err != nil {
+		return nil, nil
+	}
+	b, err := blobContent(file)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := keys.openShared(b, INDEX_LOCATION, false)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't decrypt the index of commit %v", commit.Hash))
+		return nil, err
+	}
+	index := &objectindex{}
+	err = json.Unmarshal(raw, index)
+	return index, err
+}
+
+func blobContent(file *object.File) ([]byte, error) {
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// ErrNoContent is returned when a commit has no content file at a path.
+var ErrNoContent = errors.New("no content at this revision")
+
+// ContentAt decrypts the content file at the repository relative path in
+// commit. The location it was sealed for is looked up in the index of the
+// commit when the file is stored under an id.
+func ContentAt(commit *object.Commit, relpath string, keys *Keyring) ([]byte, error) {
+	index, err := indexAt(commit, keys)
+	if err != nil {
+		return nil, err
+	}
+	return openAt(commit, relpath, index, keys)
+}
+
+// locationAt returns the location the content file at the repository
+// relative path was sealed for, given the index of its commit.
+func locationAt(relpath string, index *objectindex) string {
+	location := strings.TrimPrefix(relpath, STORE_FOLDER_NAME+"/")
+	if index != nil {
+		id := relpath[strings.LastIndex(relpath, "/")+1:]
+		for logical, objectId := range index.Ids {
+			if objectId == id {
+				return logical
+			}
+		}
+	}
+	return location
+}
+
+func openAt(commit *object.Commit, relpath string, index *objectindex, keys *Keyring) ([]byte, error) {
+	file, err := commit.File(relpath)
+	if err != nil {
+		return nil, ErrNoContent
+	}
+	b, err := blobContent(file)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't read %v in commit %v", relpath, commit.Hash))
+		return nil, err
+	}
+	location := locationAt(relpath, index)
+	_, shared := recipientsDirAt(commit, location, index)
+	return keys.openShared(b, location, shared)
+}
+
+// ResolveAt returns the commit for a revision, or the last commit made at or
+// before a date.
+func ResolveAt(repo *git.Repository, at string) (*object.Commit, error) {
+	for _, layout := range AT_DATE_FORMATS {
+		date, err := time.ParseInLocation(layout, at, time.Local)
+		if err != nil {
+			continue
+		}
+		if layout == AT_DATE_FORMATS[0] {
+			// the whole day
+			date = date.Add(24*time.Hour - time.Second)
+		}
+		var found *object.Commit
+		commits, err := repo.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+		if err != nil {
+			return nil, err
+		}
+		err = commits.ForEach(func(commit *object.Commit) error {
+			if !commit.Committer.When.After(date) {
+				found = commit
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			return nil, fmt.Errorf("no commit before %v", at)
+		}
+		return found, nil
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(at))
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't resolve revision %v", at))
+		return nil, err
+	}
+	return repo.CommitObject(*hash)
+}
+
+// GetRawJsonContentAt decrypts the object at the logical path as it was at
+// the revision or date at, following renames.
+func GetRawJsonContentAt(path string, at string, keys *Keyring) ([]byte, error) {
+	current, err := contentRelPath(path, keys)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return nil, err
+	}
+	target, err := ResolveAt(repo, at)
+	if err != nil {
+		return nil, err
+	}
+	return rawJsonContentAtCommit(repo, current, target, keys)
+}
+
+// rawJsonContentAtCommit decrypts the content file at the repository relative
+// path current as it was in target, following renames.
+func rawJsonContentAtCommit(repo *git.Repository, current string, target *object.Commit, keys *Keyring) ([]byte, error) {
+	var content []byte
+	found := false
+	err := walkObjectHistory(repo, current, true, func(commit *object.Commit, relpath string, changed bool) error {
+		if commit.Hash != target.Hash {
+			return nil
+		}
+		found = true
+		b, err := ContentAt(commit, relpath, keys)
+		if err != nil {
+			return err
+		}
+		content = b
+		return storer.ErrStop
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoContent
+	}
+	return content, nil
+}
+
+// GetValueAt returns the value at the pointer property of the object at the
+// logical path as it was at the revision or date at.
+func GetValueAt(path string, property string, at string, keys *Keyring) (interface{}, error) {
+	rawjson, err := GetRawJsonContentAt(path, at, keys)
+	if err != nil {
+		return nil, err
+	}
+	var jsonDocument interface{}
+	err = UnmarshalJson(rawjson, &jsonDocument)
+	if err != nil {
+		HandleErr(err, "Couldn't read content file as JSON object")
+		return nil, err
+	}
+	pointer, err := gojsonpointer.NewJsonPointer(property)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", property))
+		return nil, err
+	}
+	value, _, err := pointer.Get(jsonDocument)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't get value at %v", property))
+		return nil, err
+	}
+	return value, nil
+}
+
+func printCommit(commit *object.Commit, note string) {
+	author := commit.Author.Name
+	if commit.Author.Email != "" {
+		author = fmt.Sprintf("%s <%s>", author, commit.Author.Email)
+	}
+	subject := strings.SplitN(commit.Message, "\n", 2)[0]
+	if note != "" {
+		subject = fmt.Sprintf("%s (%s)", subject, note)
+	}
+	fmt.Printf("%s %s %s\n    %s\n", commit.Hash.String()[:8], commit.Author.When.Format("2006-01-02 15:04:05"), author, subject)
+}
+
+// PrintLog lists the commits that changed the object at the logical path.
+// With a pointer, only the commits that changed the value at the pointer are
+// listed, the values themselves are not printed.
+func PrintLog(path string, property *string, follow bool, keys *Keyring) error {
+	versions, err := ObjectHistory(path, follow, keys)
+	if err != nil {
+		return err
+	}
+	if property == nil {
+		for _, version := range versions {
+			printCommit(version.Commit, "")
+		}
+		return nil
+	}
+	pointer, err := gojsonpointer.NewJsonPointer(*property)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", *property))
+		return err
+	}
+	// value at the pointer in each version, nil when missing
+	values := make([]interface{}, len(versions))
+	present := make([]bool, len(versions))
+	for i, version := range versions {
+		rawjson, err := ContentAt(version.Commit, version.Path, keys)
+		if err != nil {
+			return err
+		}
+		var jsonDocument interface{}
+		err = UnmarshalJson(rawjson, &jsonDocument)
+		if err != nil {
+			return err
+		}
+		value, _, err := pointer.Get(jsonDocument)
+		values[i], present[i] = value, err == nil
+	}
+	for i, version := range versions {
+		older := i + 1
+		switch {
+		case older == len(versions) || !present[older]:
+			if present[i] {
+				printCommit(version.Commit, "set")
+			}
+		case !present[i]:
+			printCommit(version.Commit, "unset")
+		case !reflect.DeepEqual(values[i], values[older]):
+			printCommit(version.Commit, "changed")
+		}
+	}
+	return nil
+}
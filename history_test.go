@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// testRepo returns an in-memory repository and a function committing the
+// files of write and the removal of remove with message, signed when signKey
+// isn't nil. Commits are a minute apart.
+func testRepo(t *testing.T) (*git.Repository, func(message string, write map[string]string, remove []string, signKey *openpgp.Entity) plumbing.Hash) {
+	fs := memfs.New()
+	repo, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	when := time.Now()
+	commit := func(message string, write map[string]string, remove []string, signKey *openpgp.Entity) plumbing.Hash {
+		for path, content := range write {
+			err := util.WriteFile(fs, path, []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = worktree.Add(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, path := range remove {
+			_, err := worktree.Remove(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		when = when.Add(time.Minute)
+		hash, err := worktree.Commit(message, &git.CommitOptions{Author: &object.Signature{Name: AUTHOR_NAME, When: when}, SignKey: signKey})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	return repo, commit
+}
+
+func TestRenamedFrom(t *testing.T) {
+	message := "Move content from store/a to store/b\n\nMoved: store/a/x -> store/b/x\nCopied: store/c -> store/d"
+	if from := renamedFrom(message, "store/b/x"); from != "store/a/x" {
+		t.Error("Expecting store/a/x, got", from)
+	}
+	if from := renamedFrom(message, "store/d"); from != "store/c" {
+		t.Error("Expecting store/c, got", from)
+	}
+	if from := renamedFrom(message, "store/a/x"); from != "" {
+		t.Error("Expecting no rename, got", from)
+	}
+}
+
+func TestWalkObjectHistory(t *testing.T) {
+	repo, commit := testRepo(t)
+	commit("create", map[string]string{"store/a": "1"}, nil, nil)
+	commit("other", map[string]string{"store/other": "1"}, nil, nil)
+	commit("update", map[string]string{"store/a": "2"}, nil, nil)
+	commit("move\n\nMoved: store/a -> store/b", map[string]string{"store/b": "3"}, []string{"store/a"}, nil)
+	commit("update again", map[string]string{"store/b": "4"}, nil, nil)
+
+	for follow, expected := range map[bool][]string{
+		false: {"update again", "move\n\nMoved: store/a -> store/b"},
+		true:  {"update again", "move\n\nMoved: store/a -> store/b", "update", "create"},
+	} {
+		messages := []string{}
+		err := walkObjectHistory(repo, "store/b", follow, func(commit *object.Commit, path string, changed bool) error {
+			if changed {
+				messages = append(messages, commit.Message)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != len(expected) {
+			t.Fatal("Expecting", expected, "with follow", follow, "got", messages)
+		}
+		for i := range expected {
+			if messages[i] != expected[i] {
+				t.Error("Expecting", expected[i], "got", messages[i])
+			}
+		}
+	}
+}
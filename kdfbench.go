@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+const (
+	KDF_BENCH_DEFAULT_TARGET = 1000 * time.Millisecond
+	KDF_BENCH_MIN_MEMORY     = 19 * 1024
+	KDF_BENCH_MAX_MEMORY     = 1024 * 1024
+)
+
+func timeKdf(kdf kdfsettings) time.Duration {
+	var salt [PW_SALT_BYTES]byte
+	start := time.Now()
+	MakeKeyArgon2id([]byte("vstore kdf benchmark"), salt, kdf.Time, kdf.Memory, kdf.Parallelism)
+	return time.Since(start)
+}
+
+// BenchKdf looks for Argon2id costs that take about target to derive a key on
+// this machine. Memory is grown first since it is what makes guessing
+// expensive on dedicated hardware, then the time cost.
+func BenchKdf(target time.Duration) kdfsettings {
+	parallelism := runtime.NumCPU()
+	if parallelism > 4 {
+		parallelism = 4
+	}
+	kdf := kdfsettings{Time: 1, Memory: KDF_BENCH_MIN_MEMORY, Parallelism: uint8(parallelism)}
+	elapsed := timeKdf(kdf)
+	for elapsed*2 <= target && kdf.Memory*2 <= KDF_BENCH_MAX_MEMORY {
+		kdf.Memory *= 2
+		elapsed = timeKdf(kdf)
+	}
+	for elapsed < target && kdf.Time < ARGON2_MAX_TIME {
+		next := kdf
+		next.Time++
+		nextElapsed := timeKdf(next)
+		if nextElapsed > target && nextElapsed-target > target-elapsed {
+			break
+		}
+		kdf, elapsed = next, nextElapsed
+	}
+	fmt.Printf("argon2id time=%d memory=%dKiB parallelism=%d: %v\n", kdf.Time, kdf.Memory, kdf.Parallelism, elapsed)
+	return kdf
+}
+
+// KdfBench picks Argon2id costs for this machine and saves them in the
+// settings, which are re-encrypted with the new costs.
+func KdfBench(password string, settings usersettings, target time.Duration) error {
+	fmt.Printf("Benchmarking argon2id for a target unlock time of %v\n", target)
+	kdf := BenchKdf(target)
+	settings.Kdf = &kdf
+	err := CreateEncodedSettingsFile(password, settings)
+	if err != nil {
+		HandleErr(err, "Couldn't save the key derivation settings")
+		return err
+	}
+	fmt.Println("Saved key derivation settings, files will use them on their next write")
+	return nil
+}
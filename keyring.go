@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+const (
+	STORE_KEY_BYTES = 32
+)
+
+// Keyring holds the secrets and parameters used to seal and open content
+// files.
+//
+// Content files are sealed with data keys derived from a random store key,
+// the store key itself is sealed with the master key in the store key file.
+// The master key is stretched only once per invocation, to unwrap the store
+// key, and changing it only means rewrapping the store key file.
+type Keyring struct {
+	MasterKey string
+	Kdf       *kdfsettings
+	Padding   byte
+	// Identity is the X25519 private key opening objects shared with the
+	// user.
+	Identity string
+	// Format is the format of newly written objects, FORMAT_VSTORE or
+	// FORMAT_AGE.
+	Format string
+	// Auth authenticates to the remote.
+	Auth *authsettings
+	// Remotes are the remotes the store is pushed to.
+	Remotes []remotesettings
+	// SignKey signs the commits when set.
+	SignKey *openpgp.Entity
+	// AuthorName, AuthorEmail, MessageTemplate and Redact shape the
+	// commits, see CommitMessage.
+	AuthorName      string
+	AuthorEmail     string
+	MessageTemplate string
+	Redact          []string
+	storeKey        *[STORE_KEY_BYTES]byte
+	// index of the stores with encrypted names, it is sealed under the
+	// store key
+	index *objectindex
+	// tx is the open transaction, commits are staged in it
+	tx *transaction
+}
+
+func NewKeyring(settings usersettings) (*Keyring, error) {
+	padding, err := ParsePadding(settings.Padding)
+	if err != nil {
+		return nil, err
+	}
+	format, err := ParseFormat(settings.Format)
+	if err != nil {
+		return nil, err
+	}
+	var signKey *openpgp.Entity
+	if settings.SignKey != "" {
+		signKey, err = ParseSignKey(settings.SignKey)
+		if err != nil {
+			HandleErr(err, "Couldn't read the signing key")
+			return nil, err
+		}
+	}
+	return &Keyring{
+		MasterKey:       settings.MasterKey,
+		Kdf:             settings.Kdf,
+		Padding:         padding,
+		Identity:        settings.Identity,
+		Format:          format,
+		Auth:            settings.Auth,
+		Remotes:         settings.RemoteList(),
+		SignKey:         signKey,
+		AuthorName:      settings.AuthorName,
+		AuthorEmail:     settings.AuthorEmail,
+		MessageTemplate: settings.MessageTemplate,
+		Redact:          settings.Redact,
+	}, nil
+}
+
+// ReadStoreKey unwraps the store key file with masterKey.
+func ReadStoreKey(masterKey string) (*[STORE_KEY_BYTES]byte, error) {
+	path, err := GetStoreKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := OpenEnvelope(b, PasswordKey(masterKey), STORE_KEY_LOCATION)
+	if err != nil {
+		HandleErr(err, "Couldn't unwrap the store key")
+		return nil, err
+	}
+	if len(raw) != STORE_KEY_BYTES {
+		return nil, errors.New("malformed store key")
+	}
+	var storeKey [STORE_KEY_BYTES]byte
+	copy(storeKey[:], raw)
+	return &storeKey, nil
+}
+
+// WriteStoreKey wraps storeKey with masterKey and writes it to the store key
+// file. The file is not committed.
+func WriteStoreKey(storeKey *[STORE_KEY_BYTES]byte, masterKey string, settings *kdfsettings) (string, error) {
+	path, err := GetStoreKeyPath()
+	if err != nil {
+		return "", err
+	}
+	kdf, err := NewKdfParams(settings)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := SealEnvelope(storeKey[:], kdf, PasswordKey(masterKey), STORE_KEY_LOCATION, PADDING_NONE)
+	if err != nil {
+		return "", err
+	}
+	err = CreateMetaDir()
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(path, sealed, 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write store key file at path %v", path))
+		return "", err
+	}
+	return path, nil
+}
+
+// GenerateStoreKey draws a new random store key.
+func GenerateStoreKey() (*[STORE_KEY_BYTES]byte, error) {
+	var storeKey [STORE_KEY_BYTES]byte
+	_, err := io.ReadFull(rand.Reader, storeKey[:])
+	if err != nil {
+		HandleErr(err, "Couldn't get enough entropy")
+		return nil, err
+	}
+	return &storeKey, nil
+}
+
+// StoreKey returns the store key, unwrapping it on first use. When the store
+// has no key yet and create is set, a new one is generated and written, it is
+// committed along with the change that needed it.
+func (keys *Keyring) StoreKey(create bool) (*[STORE_KEY_BYTES]byte, error) {
+	if keys.storeKey != nil {
+		return keys.storeKey, nil
+	}
+	storeKey, err := ReadStoreKey(keys.MasterKey)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if !create {
+			return nil, errors.New("the store has no store key file")
+		}
+		storeKey, err = GenerateStoreKey()
+		if err != nil {
+			return nil, err
+		}
+		_, err = WriteStoreKey(storeKey, keys.MasterKey, keys.Kdf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	keys.storeKey = storeKey
+	return storeKey, nil
+}
+
+// deriveKey is the KeySource of content files: data keys come from the store
+// key or are unwrapped with the identity for shared objects, files written
+// before the store key existed are opened with the master key.
+func (keys *Keyring) deriveKey(kdf KdfParams) ([PW_KEY_BYTES]byte, error) {
+	if kdf.Id == KDF_X25519_RECIPIENTS {
+		return UnwrapFileKey(kdf.Stanzas, keys.Identity)
+	}
+	if kdf.Id == KDF_HKDF_STORE_KEY {
+		storeKey, err := keys.StoreKey(false)
+		if err != nil {
+			return [PW_KEY_BYTES]byte{}, err
+		}
+		return DeriveDataKey(storeKey, kdf.Salt)
+	}
+	return DeriveKey([]byte(keys.MasterKey), kdf)
+}
+
+// Seal encrypts plaintext with a fresh data key, for the content file at
+// location. Files sealed with the master key by older versions are moved
+// under the store key on their next write.
+func (keys *Keyring) Seal(plaintext []byte, location string) ([]byte, error) {
+	_, err := keys.StoreKey(true)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := GenerateSalt()
+	if err != nil {
+		return nil, err
+	}
+	return SealEnvelope(plaintext, KdfParams{Id: KDF_HKDF_STORE_KEY, Salt: salt}, keys.deriveKey, location, keys.Padding)
+}
+
+// SealFor encrypts plaintext for the recipients, or under the store key when
+// there are none. Objects in the age format are encrypted with the master key
+// instead of the store key.
+func (keys *Keyring) SealFor(plaintext []byte, location string, recipients []recipient) ([]byte, error) {
+	if keys.Format == FORMAT_AGE {
+		return SealAge(plaintext, recipients, keys.MasterKey, location)
+	}
+	if len(recipients) == 0 {
+		return keys.Seal(plaintext, location)
+	}
+	return SealForRecipients(plaintext, recipients, location, keys.Padding)
+}
+
+// Open decrypts the content at location, shared when a recipients file of the
+// worktree applies to it.
+func (keys *Keyring) Open(data []byte, location string) ([]byte, error) {
+	_, shared, err := recipientsDir(location, keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys.openShared(data, location, shared)
+}
+
+// openShared decrypts the content at location. Content at a shared location
+// must be sealed for recipients, content elsewhere with the store or the
+// master key: the public keys of the recipients are known to anyone able to
+// push, so content sealed for them is refused where they don't apply.
+func (keys *Keyring) openShared(data []byte, location string, shared bool) ([]byte, error) {
+	if IsAgeFile(data) {
+		passphrase := IsAgePassphraseFile(data)
+		// age -r writes files for the recipients without any key of the
+		// store, they are only read where the format is chosen
+		if !passphrase && keys.Format != FORMAT_AGE {
+			return nil, fmt.Errorf("%v is encrypted to recipients in the age format, which is only read with vstore config format age", location)
+		}
+		if passphrase == shared {
+			return nil, ErrWrongSealing
+		}
+		return OpenAge(data, keys.Identity, keys.MasterKey, location)
+	}
+	kdf, err := EnvelopeKdf(data)
+	if err != nil {
+		return nil, err
+	}
+	if (kdf.Id == KDF_X25519_RECIPIENTS) != shared {
+		return nil, ErrWrongSealing
+	}
+	return OpenEnvelope(data, keys.deriveKey, location)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T) *Keyring {
+	storeKey, err := GenerateStoreKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Keyring{
+		MasterKey: "testmasterkey",
+		Kdf:       &kdfsettings{Time: 1, Memory: 8 * 1024, Parallelism: 1},
+		Padding:   PADDING_PADME,
+		storeKey:  storeKey,
+	}
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	keys := testKeyring(t)
+	sealed, err := keys.Seal([]byte("content"), "notes/todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf, err := EnvelopeKdf(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kdf.Id != KDF_HKDF_STORE_KEY {
+		t.Error("Expecting content to be sealed under the store key, got kdf", kdf.Id)
+	}
+	plaintext, err := keys.Open(sealed, "notes/todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "content" {
+		t.Error("Expecting content to round trip, got", string(plaintext))
+	}
+	other := testKeyring(t)
+	_, err = other.Open(sealed, "notes/todo")
+	if err == nil {
+		t.Error("Expecting an error when opening with another store key")
+	}
+}
+
+func TestKeyringOpenMasterKeySealed(t *testing.T) {
+	keys := testKeyring(t)
+	kdf, err := NewKdfParams(keys.Kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealEnvelope([]byte("content"), kdf, PasswordKey(keys.MasterKey), "notes/todo", PADDING_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := keys.Open(sealed, "notes/todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "content" {
+		t.Error("Expecting master key sealed content to be readable, got", string(plaintext))
+	}
+}
+
+func TestStoreKeyCommittedWithChange(t *testing.T) {
+	storepath := testStore(t)
+	keys := testKeyring(t)
+	keys.storeKey = nil
+	err := RunBatch(strings.NewReader("set notes /a 1\nunset missing /c\n"), keys)
+	if err == nil {
+		t.Fatal("Expecting the batch to fail")
+	}
+	storeKeyPath, err := GetStoreKeyPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := PathExists(storeKeyPath); exists || keys.storeKey != nil {
+		t.Error("Expecting the store key created by a failed batch to be dropped")
+	}
+	err = StoreSetValue(filepath.Join(storepath, "notes"), "/a", "1", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.NumParents() != 0 {
+		t.Error("Expecting the store key to be added by the first change, got", commit.NumParents(), "parents")
+	}
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relpath, err := filepath.Rel(repoPath, storeKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fileHash(commit, filepath.ToSlash(relpath)); !ok {
+		t.Error("Expecting the store key to be committed with the first change")
+	}
+}
+
+func TestSealedWithMasterKey(t *testing.T) {
+	keys := testKeyring(t)
+	_, shared := testRecipient(t, "bob")
+	kdf, err := NewKdfParams(keys.Kdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeSealed, err := keys.Seal([]byte("content"), "notes/todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	masterSealed, err := SealEnvelope([]byte("content"), kdf, PasswordKey(keys.MasterKey), "notes/todo", PADDING_NONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agePassphrase, err := SealAge([]byte("content"), nil, keys.MasterKey, "notes/todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ageShared, err := SealAge([]byte("content"), []recipient{shared}, keys.MasterKey, "notes/todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for data, expected := range map[string]bool{
+		string(storeSealed):   false,
+		string(masterSealed):  true,
+		string(agePassphrase): true,
+		string(ageShared):     false,
+	} {
+		if sealedWithMasterKey([]byte(data)) != expected {
+			t.Error("Expecting sealedWithMasterKey to be", expected, "for", data[:8])
+		}
+	}
+}
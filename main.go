@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
   "github.com/sethvargo/go-password/password"
   "strings"
   "bufio"
@@ -19,11 +20,50 @@ func PrintUsage() {
 	fmt.Println("vstore reset : reset the local store")
 	fmt.Println("vstore info : print vstore information")
   fmt.Println("vstore ls: list all files")
+  fmt.Println("vstore init --local : create a store with no remote")
+  fmt.Println("vstore remote add <url> : attach a remote to a local store and push it")
+  fmt.Println("vstore remote add <name> <url> [--sync-only] : also push the store to a mirror, on every commit or on sync only")
+  fmt.Println("vstore remote remove <name> : stop pushing to a mirror")
+  fmt.Println("vstore remote : list the remotes and their push policy")
+  fmt.Println("vstore auth [--remote <name>] [none|ssh-key <key path> [user]|ssh-agent [user]|https <user>|credential-helper] : print or set how to authenticate to origin or to a mirror")
+  fmt.Println("vstore sign-key [name] : sign commits with a new OpenPGP key, print its public key")
+  fmt.Println("vstore trust [public-key-file|-] : list the keys trusted to sign commits, or trust more")
+  fmt.Println("vstore untrust <key id> : stop trusting a signing key")
+  fmt.Println("vstore --allow-unsigned ... : pull commits that aren't signed by a trusted key")
+  fmt.Println("vstore status : commits waiting to be pushed and pulled")
+  fmt.Println("vstore sync : pull, merge and push the pending commits to every remote")
+  fmt.Println("vstore --offline ... or VSTORE_OFFLINE=1 : don't reach the remote, commits stay local until sync")
 	fmt.Println("vstore get path/to/file : get content of file")
 	fmt.Println("vstore get path/to/file /jsonpointer : get value at /jsonpointer, add value to clipboard")
+	fmt.Println("vstore get path/to/file [/jsonpointer] --at <rev|date> : get the content or value as it was at a revision or date")
+	fmt.Println("vstore restore path/to/file [/jsonpointer] --to <rev|date> : write back the file or the value as it was, in a new commit")
+	fmt.Println("vstore restore --deleted path/to/file [--to <rev|date>] : write back a deleted file")
+	fmt.Println("vstore undo : revert the last vstore commit in a new commit")
+	fmt.Println("vstore diff [path/to/file|.] [rev1] [rev2] [--reveal] : decrypted diff of a file or the whole store, from before the last pull to now by default")
+	fmt.Println("vstore log path/to/file [/jsonpointer] [--follow] : list the commits that changed the file or the value, --follow across moves")
   fmt.Println("vstore set path/to/file /jsonpointer [–g|-e] : set value at /jsonpointer using value in [clipboard|-g: generate random|-e enter")
+  fmt.Println("vstore set path/to/file /jsonpointer --json|--number|--bool [value|-e] : set a typed value, parsed from value, stdin or clipboard")
+  fmt.Println("vstore set path/to/file /jsonpointer --null : set null at /jsonpointer")
+  fmt.Println("vstore patch path/to/file [patch-file|-] [--dry-run] : apply a JSON Patch or Merge Patch in a single commit, --dry-run prints the diff")
+  fmt.Println("vstore batch [script|-] : run set, unset, mvkey, cpkey, remove, mv and cp lines in a single commit, nothing is written if one fails")
   fmt.Println("vstore remove path/to/file")
+  fmt.Println("vstore unset path/to/file /jsonpointer : remove the value at /jsonpointer")
+  fmt.Println("vstore mvkey path/to/file /from /to : move the value at /from to /to")
+  fmt.Println("vstore cpkey path/to/file /from path/to/other /to : copy the value at /from to /to in the other file")
   fmt.Println("vstore create path/to/file: force create file")
+  fmt.Println("vstore mv path/to/file new/path/to/file : move file or directory")
+  fmt.Println("vstore cp path/to/file new/path/to/file : copy file or directory")
+  fmt.Println("vstore encrypt-names : store files under random ids, paths are kept in an encrypted index")
+  fmt.Println("vstore identity [name] : print your public key, register it as name for sharing")
+  fmt.Println("vstore age-identity : print your identity in the age format, keep it secret")
+  fmt.Println("vstore share path/to/dir name : encrypt the files of dir to user name as well")
+  fmt.Println("vstore unshare path/to/dir name : stop encrypting the files of dir to user name")
+  fmt.Println("vstore kdf-bench [target_ms] : tune key derivation costs for this machine, default target 1000ms")
+  fmt.Println("vstore passwd : change the local password protecting the settings")
+  fmt.Println("vstore config name [value] : print or change a setting")
+  fmt.Println("vstore rotate-master : re-encrypt the whole store with a new master key")
+  fmt.Println("vstore recovery split [--shares N] [--threshold K] : print shares of the master key, K of N rebuild it")
+  fmt.Println("vstore recovery combine : rebuild the master key from shares entered on stdin")
 }
 
 func PrintInfo() (error){
@@ -63,6 +103,9 @@ func ListFiles() error {
       if filepath.Base(path) == ".git" {
         return filepath.SkipDir
       }
+      if filepath.Base(path) == RECIPIENTS_FILE {
+        return nil
+      }
       if err != nil {
         HandleErr(err, "Couldn't list files")
         return err
@@ -76,6 +119,18 @@ func ListFiles() error {
   return err
 }
 
+func ListObjectPaths(keys *Keyring) error {
+  files, err := ListObjects(keys)
+  if err != nil {
+    HandleErr(err, "Couldn't list files")
+    return err
+  }
+  for _, file := range files {
+    fmt.Println(file)
+  }
+  return nil
+}
+
 func GeneratePassword() (string, error) {
   value, err := password.Generate(10, 3, 2, false, false)
   if err != nil {
@@ -113,6 +168,10 @@ func create_file_object(target string)(string, error) {
     HandleErr(err, "Couldn't get store path")
 		return "", err
 	}
+	if NamesEncrypted() {
+		// no directories, the file is allocated on first write
+		return target, nil
+	}
 	path := filepath.Join(storepath, target)
 	exists, _ := PathExists(path)
 	if exists {
@@ -125,34 +184,44 @@ func create_file_object(target string)(string, error) {
 	}
 	return target, nil
 }
-func remove_file_object(target string) error {
+func remove_file_object(target string, keys *Keyring) error {
   storepath, err := GetStorePath()
   if err != nil {
     HandleErr(err, "Couldn't get store path")
     return err
   }
   path := filepath.Join(storepath, target)
-  err = os.Remove(path)
-  if err != nil {
-    HandleErr(err, fmt.Sprintf("Couldn't remove at path %v", path))
-    return err
-  }
-  return StoreUpdateRemote(path)
+  return StoreRemoveObject(path, keys)
 }
-func get_value_at_pointer(path string, jsonpointer string, key string) error {
-	value, err := StoreGetValue(path, jsonpointer, key)
+func get_value_at_pointer(path string, jsonpointer string, keys *Keyring) error {
+	value, err := StoreGetValue(path, jsonpointer, keys)
 	if err != nil {
     HandleErr(err, "Couldn't get the value")
     return err
 	}
-	clipboard.WriteAll(value)
-	fmt.Println(value)
+	formatted, err := FormatValue(value)
+	if err != nil {
+    return err
+	}
+	clipboard.WriteAll(formatted)
+	fmt.Println(formatted)
   return nil
 }
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	args := os.Args[1:]
-	if len(args) == 0 || len(args) > 4 {
+	args, offline := OfflineRequested(os.Args[1:])
+	Offline = offline
+	args, AllowUnsigned = FlagRequested(args, ALLOW_UNSIGNED_FLAG)
+	// Master key recovery kit, works without settings
+	if len(args) > 0 && args[0] == "recovery" {
+		err := Recovery(args[1:], os.Getenv("VSTORE_PASSWORD"))
+		if err != nil {
+			HandleErr(err, "Couldn't run the recovery")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(args) == 0 || len(args) > 5 {
 		PrintUsage()
 		os.Exit(1)
 	}
@@ -166,12 +235,10 @@ func main() {
 			Reset()
 			os.Exit(0)
 		}
-    if args[0] == "ls" {
+    if args[0] == "ls" && !NamesEncrypted() {
       ListFiles()
       os.Exit(0)
     }
-		PrintUsage()
-		os.Exit(1)
 	}
 
 	// Get the settings
@@ -181,23 +248,395 @@ func main() {
     HandleErr(err, "Couldn't get the settings")
     os.Exit(1)
 	}
+	keys, err := NewKeyring(settings)
+	if err != nil {
+    HandleErr(err, "Couldn't read the settings")
+    os.Exit(1)
+	}
+
+	// Tune the key derivation
+	if args[0] == "kdf-bench" {
+		target := KDF_BENCH_DEFAULT_TARGET
+		if len(args) == 2 {
+			ms, err := strconv.Atoi(args[1])
+			if err == nil && ms <= 0 {
+				err = errors.New("target time must be positive")
+			}
+			if err != nil {
+				HandleErr(err, fmt.Sprintf("Couldn't parse target time in milliseconds %v", args[1]))
+				os.Exit(1)
+			}
+			target = time.Duration(ms) * time.Millisecond
+		}
+		err = KdfBench(password, settings, target)
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Read or change a setting
+	if args[0] == "config" && (len(args) == 2 || len(args) == 3) {
+		var value *string
+		if len(args) == 3 {
+			value = &args[2]
+		}
+		err = Config(password, settings, args[1], value)
+		if err != nil {
+			HandleErr(err, "Couldn't access the setting")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Change the local password
+	if args[0] == "passwd" && len(args) == 1 {
+		err = ChangePassword(settings)
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Authenticate to the remote
+	if args[0] == "auth" && len(args) <= 6 {
+		err = ConfigureAuth(password, settings, args[1:])
+		if err != nil {
+			HandleErr(err, "Couldn't set the authentication")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Create a store with no remote
+	if args[0] == "init" && len(args) == 2 && args[1] == "--local" {
+		err = InitLocalStore(keys)
+		if err != nil {
+			HandleErr(err, "Couldn't create the local store")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Attach a remote to a local store, or a mirror to push to
+	if args[0] == "remote" && len(args) >= 3 && len(args) <= 5 && args[1] == "add" {
+		name, url, push := REMOTE_NAME, args[2], PUSH_ALWAYS
+		if len(args) >= 4 {
+			name, url = args[2], args[3]
+		}
+		if len(args) == 5 && args[4] == SYNC_ONLY_FLAG {
+			push = PUSH_SYNC
+		} else if len(args) == 5 {
+			PrintUsage()
+			os.Exit(1)
+		}
+		err = AddRemote(name, url, push, password, settings, keys)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't add remote %v", url))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if args[0] == "remote" && len(args) == 3 && args[1] == "remove" {
+		err = RemoveRemote(args[2], password, settings)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't remove remote %v", args[2]))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if args[0] == "remote" && len(args) == 1 {
+		ListRemotes(settings)
+		os.Exit(0)
+	}
 
 	// Update the store
-	err = UpdateStore(settings.Remote)
+	err = UpdateStore(settings.Remote, keys)
 	if err != nil {
     HandleErr(err, "Couldn't update the sore")
     os.Exit(1)
 	}
 
+	// List logical paths from the index
+	if args[0] == "ls" && len(args) == 1 {
+		err = ListObjectPaths(keys)
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Store file names in the encrypted index
+	if args[0] == "encrypt-names" && len(args) == 1 {
+		err = EncryptNames(keys)
+		if err != nil {
+			HandleErr(err, "Couldn't encrypt file names")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Print the public key of the user
+	if args[0] == "identity" && len(args) <= 2 {
+		name := ""
+		if len(args) == 2 {
+			name = args[1]
+		}
+		err = Identity(password, settings, name, keys)
+		if err != nil {
+			HandleErr(err, "Couldn't get the identity")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Export the identity for the age CLI
+	if args[0] == "age-identity" && len(args) == 1 {
+		if settings.Identity == "" {
+			HandleErr(errors.New("no identity in settings"), "Couldn't export the identity, run vstore identity first")
+			os.Exit(1)
+		}
+		encoded, err := AgeIdentity(settings.Identity)
+		if err != nil {
+			HandleErr(err, "Couldn't export the identity")
+			os.Exit(1)
+		}
+		fmt.Println(encoded)
+		os.Exit(0)
+	}
+	// Change the recipients of a directory
+	if (args[0] == "share" || args[0] == "unshare") && len(args) == 3 {
+		if args[0] == "share" {
+			err = Share(args[1], args[2], settings, keys)
+		} else {
+			err = Unshare(args[1], args[2], keys)
+		}
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't %v %v with %v", args[0], args[1], args[2]))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Run a script of writes in a single commit
+	if args[0] == "batch" && len(args) <= 2 {
+		script := os.Stdin
+		if len(args) == 2 && args[1] != "-" {
+			script, err = os.Open(args[1])
+			if err != nil {
+				HandleErr(err, fmt.Sprintf("Couldn't open the batch script %v", args[1]))
+				os.Exit(1)
+			}
+			defer script.Close()
+		}
+		err = RunBatch(script, keys)
+		if err != nil {
+			HandleErr(err, "Couldn't run the batch")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Sign commits and trust the keys of the others
+	if args[0] == "sign-key" && len(args) <= 2 {
+		name := ""
+		if len(args) == 2 {
+			name = args[1]
+		}
+		err = SignKey(password, settings, name, keys)
+		if err != nil {
+			HandleErr(err, "Couldn't set up the signing key")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if args[0] == "trust" && len(args) == 1 {
+		err = ListTrusted()
+		if err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if args[0] == "trust" && len(args) == 2 {
+		armored, err := ReadInputFile(args[1])
+		if err != nil {
+			os.Exit(1)
+		}
+		err = Trust(armored, keys)
+		if err != nil {
+			HandleErr(err, "Couldn't trust the keys")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if args[0] == "untrust" && len(args) == 2 {
+		err = Untrust(args[1], keys)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't untrust %v", args[1]))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Pending pushes and pulls
+	if args[0] == "status" && len(args) == 1 {
+		err = StoreStatus(keys)
+		if err != nil {
+			HandleErr(err, "Couldn't get the store status")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Pull, merge and push
+	if args[0] == "sync" && len(args) == 1 {
+		err = StoreSync(keys)
+		if err != nil {
+			HandleErr(err, "Couldn't sync the store")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Revert the last vstore commit
+	if args[0] == "undo" && len(args) == 1 {
+		err = Undo(keys)
+		if err != nil {
+			HandleErr(err, "Couldn't undo the last commit")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Write back a deleted file, no fuzzy matching
+	if args[0] == "restore" && len(args) >= 3 && args[1] == "--deleted" {
+		rev := ""
+		if len(args) == 5 && args[3] == "--to" {
+			rev = args[4]
+		} else if len(args) != 3 {
+			PrintUsage()
+			os.Exit(1)
+		}
+		err = StoreRestoreDeleted(args[2], rev, keys)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't restore deleted file %v", args[2]))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Decrypted diff of a file or of the whole store
+	if args[0] == "diff" {
+		reveal := false
+		positional := []string{}
+		for _, arg := range args[1:] {
+			if arg == "--reveal" {
+				reveal = true
+			} else {
+				positional = append(positional, arg)
+			}
+		}
+		if len(positional) > 3 {
+			PrintUsage()
+			os.Exit(1)
+		}
+		for len(positional) < 3 {
+			positional = append(positional, "")
+		}
+		path := ""
+		if positional[0] != "" && positional[0] != "." {
+			path, err = FindObjectPath(positional[0], StdinSelector, keys)
+			if err != nil {
+				HandleErr(err, fmt.Sprintf("Couldn't find path from %v", positional[0]))
+				os.Exit(1)
+			}
+		}
+		err = PrintDiff(path, positional[1], positional[2], reveal, keys)
+		if err != nil {
+			HandleErr(err, "Couldn't diff the store")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	// Re-encrypt the store with a new master key
+	if args[0] == "rotate-master" && len(args) == 1 {
+		err = RotateMasterKey(password, settings, keys)
+		if err != nil {
+			HandleErr(err, "Couldn't rotate the master key")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if len(args) < 2 {
+		PrintUsage()
+		os.Exit(1)
+	}
+	// Move or copy a whole directory, no fuzzy matching
+	if (args[0] == "mv" || args[0] == "cp") && len(args) == 3 {
+		isDir, err := IsObjectDir(args[1], keys)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't look for directory %v", args[1]))
+			os.Exit(1)
+		}
+		if isDir {
+			storepath, err := GetStorePath()
+			if err != nil {
+				HandleErr(err, "Couldn't get store path")
+				os.Exit(1)
+			}
+			dirpath := filepath.Join(storepath, args[1])
+			if args[0] == "mv" {
+				err = StoreMoveObject(dirpath, args[2], keys)
+			} else {
+				err = StoreCopyObject(dirpath, args[2], keys)
+			}
+			if err != nil {
+				HandleErr(err, fmt.Sprintf("Couldn't %v directory %v to %v", args[0], args[1], args[2]))
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
 	// Get content file path
 	rel_filepath := args[1]
-	path, err := FindObjectPath(rel_filepath, StdinSelector)
+	path, err := FindObjectPath(rel_filepath, StdinSelector, keys)
 	if err != nil {
     HandleErr(err, fmt.Sprintf("Couldn't find path from %v", rel_filepath))
 		os.Exit(1)
 	}
 
 	cmd := args[0]
+  // Read an older version
+  at := ""
+  if cmd == "get" {
+    for i := 2; i < len(args)-1; i++ {
+      if args[i] == "--at" {
+        at = args[i+1]
+        args = append(args[:i], args[i+2:]...)
+        break
+      }
+    }
+  }
+  // Write back an older version
+  if cmd == "restore" && (len(args) == 4 || len(args) == 5) && args[len(args)-2] == "--to" {
+    var property *string
+    if len(args) == 5 {
+      property = &args[2]
+    }
+    err := StoreRestore(path, property, args[len(args)-1], keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't restore file at path %v", path))
+      os.Exit(1)
+    }
+    os.Exit(0)
+  }
+  // List the history
+  if cmd == "log" && len(args) <= 4 {
+    var property *string
+    follow := false
+    for _, arg := range args[2:] {
+      if arg == "--follow" {
+        follow = true
+      } else {
+        value := arg
+        property = &value
+      }
+    }
+    err := PrintLog(path, property, follow, keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't read the history of file at path %v", path))
+      os.Exit(1)
+    }
+    os.Exit(0)
+  }
   // case 0 : Create file
   if cmd == "create" && len(args) == 2 {
     rel_path, err := create_file_object(rel_filepath)
@@ -211,7 +650,7 @@ func main() {
       os.Exit(1)
     }
     path = filepath.Join(storepath, rel_path)
-    err = StoreSetValue(path, "/touchobject", "create", settings.MasterKey)
+    err = StoreSetValue(path, "/touchobject", "create", keys)
     if err != nil {
       HandleErr(err, fmt.Sprintf("Couldn't write file at path %v", path))
       os.Exit(1)
@@ -220,16 +659,93 @@ func main() {
   }
   // Remove file
   if cmd == "remove" && len(args) == 2 {
-    err := remove_file_object(rel_filepath)
+    err := remove_file_object(rel_filepath, keys)
     if err != nil {
       HandleErr(err, fmt.Sprintf("Couldn't remove file at path %v", rel_filepath))
       os.Exit(1)
     }
     os.Exit(0)
+  }
+  // Move file
+  if cmd == "mv" && len(args) == 3 {
+    err := StoreMoveObject(path, args[2], keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't move file at path %v to %v", path, args[2]))
+      os.Exit(1)
+    }
+    os.Exit(0)
+  }
+  // Copy file
+  if cmd == "cp" && len(args) == 3 {
+    err := StoreCopyObject(path, args[2], keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't copy file at path %v to %v", path, args[2]))
+      os.Exit(1)
+    }
+    os.Exit(0)
+  }
+  // Remove a value
+  if cmd == "unset" && len(args) == 3 {
+    err := StoreUnsetValue(path, args[2], keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't unset %v at path %v", args[2], path))
+      os.Exit(1)
+    }
+    os.Exit(0)
+  }
+  // Move a value inside a file
+  if cmd == "mvkey" && len(args) == 4 {
+    err := StoreMoveValue(path, args[2], args[3], keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't move %v to %v at path %v", args[2], args[3], path))
+      os.Exit(1)
+    }
+    os.Exit(0)
+  }
+  // Copy a value to another file
+  if cmd == "cpkey" && len(args) == 5 {
+    target, err := FindObjectPath(args[3], StdinSelector, keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't find path from %v", args[3]))
+      os.Exit(1)
+    }
+    err = StoreCopyValue(path, args[2], target, args[4], keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't copy %v at path %v to %v at path %v", args[2], path, args[4], target))
+      os.Exit(1)
+    }
+    os.Exit(0)
+  }
+  // Apply a JSON Patch or Merge Patch
+  if cmd == "patch" && len(args) <= 4 {
+    source, dryRun := "-", false
+    for _, arg := range args[2:] {
+      if arg == "--dry-run" {
+        dryRun = true
+      } else {
+        source = arg
+      }
+    }
+    patch, err := ReadInputFile(source)
+    if err != nil {
+      os.Exit(1)
+    }
+    err = StorePatch(path, patch, dryRun, keys)
+    if err != nil {
+      HandleErr(err, fmt.Sprintf("Couldn't patch file at path %v", path))
+      os.Exit(1)
+    }
+    os.Exit(0)
   }
 	// case 1 : Get file content
 	if cmd == "get" && len(args) == 2 {
-		rawjson, err := GetRawJsonContent(path, settings.MasterKey)
+		var rawjson []byte
+		var err error
+		if at != "" {
+			rawjson, err = GetRawJsonContentAt(path, at, keys)
+		} else {
+			rawjson, err = GetRawJsonContent(path, keys)
+		}
 		if err != nil {
 			HandleErr(err, fmt.Sprintf("Couldn't get the content of file at path %v", path))
       os.Exit(1)
@@ -240,7 +756,21 @@ func main() {
 	jsonpointer := args[2]
 	// case 2 : Get value of file at json pointer
 	if cmd == "get" && len(args) == 3 {
-    err := get_value_at_pointer(path, jsonpointer, settings.MasterKey)
+    if at != "" {
+      value, err := GetValueAt(path, jsonpointer, at, keys)
+      if err != nil {
+        HandleErr(err, fmt.Sprintf("Couldn't read the value at path %v, json path: %v, at %v", path, jsonpointer, at))
+        os.Exit(1)
+      }
+      formatted, err := FormatValue(value)
+      if err != nil {
+        os.Exit(1)
+      }
+      clipboard.WriteAll(formatted)
+      fmt.Println(formatted)
+      os.Exit(0)
+    }
+    err := get_value_at_pointer(path, jsonpointer, keys)
 		if err != nil {
       HandleErr(err, fmt.Sprintf("Couldn't read the value at path %v, json path: %v", path, jsonpointer))
       os.Exit(1)
@@ -248,9 +778,21 @@ func main() {
     os.Exit(0)
 	}
 	// case 3 : Set value of file at json pointer
-	if cmd == "set" && len(args) <= 4 {
+	if cmd == "set" && len(args) <= 5 {
+    kind := VALUE_STRING
+    if len(args) >= 4 && IsValueType(args[3]) {
+      kind = args[3]
+      args = append(args[:3], args[4:]...)
+    }
     value, err := "", errors.New("")
-    if len(args) == 4 && args[3] == "-g" {
+    if kind == VALUE_NULL && len(args) == 3 {
+      value = ""
+    } else if len(args) == 4 && kind != VALUE_STRING && args[3] != "-g" && args[3] != "-e" {
+      value = args[3]
+    } else if len(args) > 4 {
+      PrintUsage()
+      os.Exit(1)
+    } else if len(args) == 4 && args[3] == "-g" {
       value, err = GeneratePassword()
       if err != nil {
         HandleErr(err, "Couldn't generate value")
@@ -276,12 +818,19 @@ func main() {
         os.Exit(1)
 		  }
     }
-		err = StoreSetValue(path, jsonpointer, value, settings.MasterKey)
+		typed, err := ParseValue(kind, value)
+		if err != nil {
+      HandleErr(err, "Couldn't parse the value")
+		  os.Exit(1)
+		}
+		err = StoreSetValue(path, jsonpointer, typed, keys)
 		if err != nil {
       HandleErr(err, fmt.Sprintf("Couldn't set the value at path: %v, jsonpointer: %v, value: %v ", path, jsonpointer, value))
 		  os.Exit(1)
     }
-		get_value_at_pointer(path, jsonpointer, settings.MasterKey)
+		get_value_at_pointer(path, jsonpointer, keys)
 		os.Exit(0)
 	}
+	PrintUsage()
+	os.Exit(1)
 }
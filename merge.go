@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Concurrent edits from several machines are merged by vstore rather than git:
+// content files are decrypted on both sides and merged pointer by pointer
+// against their common version, only the pointers changed differently on
+// both sides are conflicts. The merge is committed with both heads as
+// parents, then pushed.
+const (
+	REMOTE_NAME        = "origin"
+	MERGE_MAX_RETRIES  = 3
+	MERGE_COMMIT_TITLE = "Merge remote changes"
+)
+
+// jsonslot is a JSON value that may be missing.
+type jsonslot struct {
+	Present bool
+	Value   interface{}
+}
+
+type mergeconflict struct {
+	Pointer string
+	Base    jsonslot
+	Ours    jsonslot
+	Theirs  jsonslot
+}
+
+// conflictresolver picks the merged value of a conflict in the object at
+// location.
+type conflictresolver func(location string, conflict mergeconflict) (jsonslot, error)
+
+// MergeResolver resolves the conflicts of merges, it asks on stdin.
+var MergeResolver conflictresolver = StdinResolver
+
+func sameSlot(a jsonslot, b jsonslot) bool {
+	return a.Present == b.Present && (!a.Present || reflect.DeepEqual(a.Value, b.Value))
+}
+
+// MergeJson merges the changes from base to ours and from base to theirs.
+// Objects are merged member by member, other values and arrays as a whole.
+func MergeJson(base jsonslot, ours jsonslot, theirs jsonslot, resolve func(conflict mergeconflict) (jsonslot, error)) (jsonslot, error) {
+	return mergeSlots("", base, ours, theirs, resolve)
+}
+
+func mergeSlots(pointer string, base jsonslot, ours jsonslot, theirs jsonslot, resolve func(conflict mergeconflict) (jsonslot, error)) (jsonslot, error) {
+	if sameSlot(ours, theirs) || sameSlot(base, theirs) {
+		return ours, nil
+	}
+	if sameSlot(base, ours) {
+		return theirs, nil
+	}
+	oursObject, oursIsObject := ours.Value.(map[string]interface{})
+	theirsObject, theirsIsObject := theirs.Value.(map[string]interface{})
+	if ours.Present && theirs.Present && oursIsObject && theirsIsObject {
+		baseObject, ok := base.Value.(map[string]interface{})
+		if !base.Present || !ok {
+			baseObject = map[string]interface{}{}
+		}
+		keys := []string{}
+		for key := range oursObject {
+			keys = append(keys, key)
+		}
+		for key := range theirsObject {
+			if _, ok := oursObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		for key := range baseObject {
+			_, inOurs := oursObject[key]
+			_, inTheirs := theirsObject[key]
+			if !inOurs && !inTheirs {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		merged := map[string]interface{}{}
+		for _, key := range keys {
+			slot := func(object map[string]interface{}) jsonslot {
+				value, ok := object[key]
+				return jsonslot{Present: ok, Value: value}
+			}
+			child, err := mergeSlots(pointer+"/"+escapePointerToken(key), slot(baseObject), slot(oursObject), slot(theirsObject), resolve)
+			if err != nil {
+				return jsonslot{}, err
+			}
+			if child.Present {
+				merged[key] = child.Value
+			}
+		}
+		return jsonslot{Present: true, Value: merged}, nil
+	}
+	return resolve(mergeconflict{Pointer: pointer, Base: base, Ours: ours, Theirs: theirs})
+}
+
+// StdinResolver asks which side of a conflict to keep, values are only shown
+// on request.
+func StdinResolver(location string, conflict mergeconflict) (jsonslot, error) {
+	pointer := conflict.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	describe := func(slot jsonslot) string {
+		if !slot.Present {
+			return "(removed)"
+		}
+		return formatDiffValue(slot.Value)
+	}
+	for try := 0; try < 5; try++ {
+		fmt.Printf("Conflict in %s at %s\n", location, pointer)
+		fmt.Println(" o => keep ours (this machine)")
+		fmt.Println(" t => take theirs (remote)")
+		fmt.Println(" s => show both values")
+		var choice string
+		fmt.Scanln(&choice)
+		switch choice {
+		case "o":
+			return conflict.Ours, nil
+		case "t":
+			return conflict.Theirs, nil
+		case "s":
+			fmt.Printf(" ours: %s\n theirs: %s\n", describe(conflict.Ours), describe(conflict.Theirs))
+		}
+	}
+	return jsonslot{}, fmt.Errorf("conflict at %v in %v not resolved", pointer, location)
+}
+
+// changedNames returns the paths of the files that differ between two
+// commits.
+func changedNames(from *object.Commit, to *object.Commit) (map[string]bool, error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, change := range changes {
+		if change.From.Name != "" {
+			names[change.From.Name] = true
+		}
+		if change.To.Name != "" {
+			names[change.To.Name] = true
+		}
+	}
+	return names, nil
+}
+
+// blobAt returns the content of the file at name in commit, nil when there is
+// none.
+func blobAt(commit *object.Commit, name string) ([]byte, error) {
+	file, err := commit.File(name)
+	if err != nil {
+		return nil, nil
+	}
+	return blobContent(file)
+}
+
+// mergeFile merges the versions of the file at name changed on both sides.
+// It returns the merged content, nil when the file is removed.
+func mergeFile(name string, base *object.Commit, ours *object.Commit, theirs *object.Commit, keys *Keyring) ([]byte, error) {
+	usersName := META_FOLDER_NAME + "/" + USERS_FILE
+	isContent := strings.HasPrefix(name, STORE_FOLDER_NAME+"/") && !strings.HasSuffix(name, "/"+RECIPIENTS_FILE)
+	if !isContent && name != INDEX_LOCATION && name != usersName {
+		return nil, fmt.Errorf("%v changed on both sides, merge it manually", name)
+	}
+	commits := []*object.Commit{base, ours, theirs}
+	slots := make([]jsonslot, len(commits))
+	locations := make([]string, len(commits))
+	for i, commit := range commits {
+		b, err := blobAt(commit, name)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		if isContent {
+			index, err := indexAt(commit, keys)
+			if err != nil {
+				return nil, err
+			}
+			locations[i] = locationAt(name, index)
+			_, shared := recipientsDirAt(commit, locations[i], index)
+			b, err = keys.openShared(b, locations[i], shared)
+			if err != nil {
+				return nil, err
+			}
+		} else if name == INDEX_LOCATION {
+			b, err = keys.openShared(b, INDEX_LOCATION, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		slots[i].Present = true
+		err = UnmarshalJson(b, &slots[i].Value)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't read %v as JSON", name))
+			return nil, err
+		}
+	}
+	// the location of the merged object, ours first
+	location := name
+	for _, i := range []int{1, 2, 0} {
+		if locations[i] != "" {
+			location = locations[i]
+			break
+		}
+	}
+	display := location
+	if name == INDEX_LOCATION {
+		display = "the index"
+	}
+	merged, err := MergeJson(slots[0], slots[1], slots[2], func(conflict mergeconflict) (jsonslot, error) {
+		return MergeResolver(display, conflict)
+	})
+	if err != nil || !merged.Present {
+		return nil, err
+	}
+	b, err := json.Marshal(merged.Value)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isContent:
+		recipients, err := RecipientsFor(location, keys)
+		if err != nil {
+			return nil, err
+		}
+		return keys.SealFor(b, location, recipients)
+	case name == INDEX_LOCATION:
+		return keys.Seal(b, INDEX_LOCATION)
+	}
+	return json.MarshalIndent(merged.Value, "", "  ")
+}
+
+// writeRepoFile writes content at the repository relative name, removes the
+// file when content is nil, and returns its path.
+func writeRepoFile(name string, content []byte) (string, error) {
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(repoPath, filepath.FromSlash(name))
+	if content == nil {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			HandleErr(err, fmt.Sprintf("Couldn't remove %v", name))
+			return "", err
+		}
+		return path, nil
+	}
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(path, content, 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write %v", name))
+	}
+	return path, err
+}
+
+// StoreMerge fetches the remote and merges its changes into the local
+// branch, fast forwarding when there is nothing local to merge.
+func StoreMerge(keys *Keyring) error {
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	auth, err := keys.RepoAuth(repo)
+	if err != nil {
+		return err
+	}
+	err = repo.Fetch(&git.FetchOptions{RemoteName: REMOTE_NAME, Auth: auth})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		HandleErr(err, "Couldn't fetch the remote")
+		return err
+	}
+	err = VerifyIncoming(repo)
+	if err != nil {
+		HandleErr(err, "Refusing the remote changes")
+		return err
+	}
+	_, err = mergeRemote(repo, keys)
+	return err
+}
+
+// mergeRemote merges the remote tracking branch as last fetched into the
+// local branch, fast forwarding when there is nothing local to merge. It
+// tells whether a merge commit was made.
+func mergeRemote(repo *git.Repository, keys *Keyring) (bool, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return false, err
+	}
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(REMOTE_NAME, head.Name().Short()), true)
+	if err != nil {
+		HandleErr(err, "Couldn't find the remote branch")
+		return false, err
+	}
+	ours, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return false, err
+	}
+	theirs, err := repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return false, err
+	}
+	if ours.Hash == theirs.Hash {
+		return false, nil
+	}
+	behind, err := theirs.IsAncestor(ours)
+	if err != nil || behind {
+		return false, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+	err = repo.Storer.SetReference(plumbing.NewHashReference(ORIG_HEAD, ours.Hash))
+	if err != nil {
+		return false, err
+	}
+	// the index may change under the keyring
+	keys.index = nil
+	ahead, err := ours.IsAncestor(theirs)
+	if err != nil {
+		return false, err
+	}
+	if ahead {
+		err = repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), theirs.Hash))
+		if err != nil {
+			return false, err
+		}
+		return false, worktree.Reset(&git.ResetOptions{Commit: theirs.Hash, Mode: git.MergeReset})
+	}
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return false, err
+	}
+	if len(bases) == 0 {
+		return false, errors.New("the local and remote stores have no common history")
+	}
+	base := bases[0]
+	oursChanged, err := changedNames(base, ours)
+	if err != nil {
+		return false, err
+	}
+	theirsChanged, err := changedNames(base, theirs)
+	if err != nil {
+		return false, err
+	}
+	// nothing is left half merged: the files written so far are put back
+	// when a conflict isn't resolved or the commit fails
+	dirty, err := DirtyFiles()
+	if err != nil {
+		return false, err
+	}
+	paths, err := writeMerge(base, ours, theirs, oursChanged, theirsChanged, keys)
+	if err == nil {
+		err = commitMerge(worktree, paths, ours, theirs, keys)
+	}
+	if err != nil {
+		resetErr := worktree.Reset(&git.ResetOptions{Commit: ours.Hash, Mode: git.MixedReset})
+		if resetErr != nil {
+			HandleErr(resetErr, "Couldn't reset the index, check vstore status")
+		}
+		return false, rollbackFiles(dirty, keys, err)
+	}
+	return true, nil
+}
+
+// writeMerge writes the files changed remotely to the worktree, merged with
+// the local changes, and returns their paths.
+func writeMerge(base *object.Commit, ours *object.Commit, theirs *object.Commit, oursChanged map[string]bool, theirsChanged map[string]bool, keys *Keyring) ([]string, error) {
+	names := []string{}
+	for name := range theirsChanged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	paths := []string{}
+	conflicting := []string{}
+	// take the files only changed remotely first, recipients included
+	for _, name := range names {
+		if oursChanged[name] {
+			conflicting = append(conflicting, name)
+			continue
+		}
+		content, err := blobAt(theirs, name)
+		if err != nil {
+			return nil, err
+		}
+		path, err := writeRepoFile(name, content)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	for _, name := range conflicting {
+		oursHash, inOurs := fileHash(ours, name)
+		theirsHash, inTheirs := fileHash(theirs, name)
+		if inOurs == inTheirs && oursHash == theirsHash {
+			continue
+		}
+		content, err := mergeFile(name, base, ours, theirs, keys)
+		if err != nil {
+			return nil, err
+		}
+		path, err := writeRepoFile(name, content)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	keys.index = nil
+	return paths, nil
+}
+
+// commitMerge commits the merged paths with both heads as parents.
+func commitMerge(worktree *git.Worktree, paths []string, ours *object.Commit, theirs *object.Commit, keys *Keyring) error {
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		relpath, err := filepath.Rel(repoPath, path)
+		if err != nil {
+			return err
+		}
+		_, err = worktree.Add(relpath)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't add file %v to index", relpath))
+			return err
+		}
+	}
+	signature := keys.Signature(time.Now())
+	_, err = worktree.Commit(MERGE_COMMIT_TITLE, &git.CommitOptions{
+		Author:  &signature,
+		Parents: []plumbing.Hash{ours.Hash, theirs.Hash},
+		SignKey: keys.SignKey,
+	})
+	if err != nil {
+		HandleErr(err, "Couldn't commit the merge")
+	}
+	return err
+}
+
+// isNonFastForward tells whether a push was rejected because the remote has
+// commits the local branch doesn't.
+func isNonFastForward(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "non-fast-forward update")
+}
+
+// PushStore pushes the local branch, merging the remote changes first when
+// the push is rejected.
+func PushStore(repo *git.Repository, keys *Keyring) error {
+	auth, err := keys.RepoAuth(repo)
+	if err != nil {
+		return err
+	}
+	err = repo.Push(&git.PushOptions{RemoteName: REMOTE_NAME, Auth: auth})
+	for try := 0; try < MERGE_MAX_RETRIES && isNonFastForward(err); try++ {
+		err = StoreMerge(keys)
+		if err != nil {
+			HandleErr(err, "Couldn't merge the remote changes")
+			return err
+		}
+		err = repo.Push(&git.PushOptions{RemoteName: REMOTE_NAME, Auth: auth})
+	}
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
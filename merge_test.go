@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func present(t *testing.T, s string) jsonslot {
+	return jsonslot{Present: true, Value: decodeJson(t, s)}
+}
+
+func TestMergeJson(t *testing.T) {
+	base := present(t, `{"login": "john", "password": "a", "port": 80, "old": true, "tags": ["x"]}`)
+	ours := present(t, `{"login": "john", "password": "b", "port": 80, "tags": ["x", "y"]}`)
+	theirs := present(t, `{"login": "jane", "password": "a", "port": 8080, "old": true, "tags": ["x"], "new": 1}`)
+	conflicts := []string{}
+	merged, err := MergeJson(base, ours, theirs, func(conflict mergeconflict) (jsonslot, error) {
+		conflicts = append(conflicts, conflict.Pointer)
+		return conflict.Theirs, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := decodeJson(t, `{"login": "jane", "password": "b", "port": 8080, "tags": ["x", "y"], "new": 1}`)
+	if !reflect.DeepEqual(merged.Value, expected) {
+		t.Error("Expecting", expected, "got", merged.Value)
+	}
+	if len(conflicts) != 0 {
+		t.Error("Expecting no conflict, got", conflicts)
+	}
+}
+
+func TestMergeJsonConflicts(t *testing.T) {
+	base := present(t, `{"password": "a", "nested": {"keep": 1, "x": 1}}`)
+	ours := present(t, `{"password": "b", "nested": {"keep": 1}}`)
+	theirs := present(t, `{"password": "c", "nested": {"keep": 1, "x": 2}, "added": 1}`)
+	conflicts := []string{}
+	merged, err := MergeJson(base, ours, theirs, func(conflict mergeconflict) (jsonslot, error) {
+		conflicts = append(conflicts, conflict.Pointer)
+		return conflict.Ours, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/nested/x", "/password"}
+	if !reflect.DeepEqual(conflicts, expected) {
+		t.Error("Expecting conflicts", expected, "got", conflicts)
+	}
+	if !reflect.DeepEqual(merged.Value, decodeJson(t, `{"password": "b", "nested": {"keep": 1}, "added": 1}`)) {
+		t.Error("Unexpected merge", merged.Value)
+	}
+	// an object created on both sides merges against an empty base
+	merged, err = MergeJson(jsonslot{}, present(t, `{"a": 1}`), present(t, `{"b": 2}`), nil)
+	if err != nil || !reflect.DeepEqual(merged.Value, decodeJson(t, `{"a": 1, "b": 2}`)) {
+		t.Error("Expecting both members, got", merged.Value, err)
+	}
+}
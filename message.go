@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Commits are authored by the user of the settings, vstore by default. A
+// message template replaces the default subject of the commits writing a
+// single file; its placeholders are the operation, the path and the pointer
+// changed, and the host name. Paths matching a redaction pattern, and every
+// path when names are encrypted, are kept out of the messages along with
+// their pointers.
+const (
+	TEMPLATE_OPERATION = "{operation}"
+	TEMPLATE_PATH      = "{path}"
+	TEMPLATE_POINTER   = "{pointer}"
+	TEMPLATE_HOST      = "{host}"
+	MESSAGE_REDACTED   = "[redacted]"
+)
+
+// Signature returns the commit author of the user at when.
+func (keys *Keyring) Signature(when time.Time) object.Signature {
+	signature := object.Signature{Name: AUTHOR_NAME, Email: AUTHOR_EMAIL, When: when}
+	if keys.AuthorName != "" {
+		signature.Name = keys.AuthorName
+	}
+	if keys.AuthorEmail != "" {
+		signature.Email = keys.AuthorEmail
+	}
+	return signature
+}
+
+// IsAuthor tells whether the user authored commit.
+func (keys *Keyring) IsAuthor(commit *object.Commit) bool {
+	signature := keys.Signature(time.Time{})
+	return commit.Author.Name == signature.Name && commit.Author.Email == signature.Email
+}
+
+// IsRedacted tells whether the store relative location must be kept out of
+// commit messages. A pattern matches the location, one of its parent
+// directories, or is a glob matching the location.
+func IsRedacted(location string, keys *Keyring) bool {
+	if NamesEncrypted() {
+		return true
+	}
+	location = strings.Trim(location, "/")
+	for _, pattern := range keys.Redact {
+		pattern = strings.Trim(pattern, "/")
+		if location == pattern || strings.HasPrefix(location, pattern+"/") {
+			return true
+		}
+		if matched, _ := path.Match(pattern, location); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// CommitMessage returns the message of an operation on the object at the
+// store relative location: subject when the user has no template, the
+// template filled in otherwise. The lines after the subject are kept.
+func (keys *Keyring) CommitMessage(operation string, location string, pointer string, subject string) string {
+	if keys.MessageTemplate == "" {
+		return subject
+	}
+	location = strings.Trim(location, "/")
+	if IsRedacted(location, keys) {
+		location = MESSAGE_REDACTED
+		if pointer != "" {
+			pointer = MESSAGE_REDACTED
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	message := strings.NewReplacer(
+		TEMPLATE_OPERATION, operation,
+		TEMPLATE_PATH, location,
+		TEMPLATE_POINTER, pointer,
+		TEMPLATE_HOST, host,
+	).Replace(keys.MessageTemplate)
+	// no double spaces around empty placeholders
+	message = strings.Join(strings.Fields(message), " ")
+	lines := strings.SplitN(subject, "\n", 2)
+	if len(lines) == 2 {
+		message += "\n" + lines[1]
+	}
+	return message
+}
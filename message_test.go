@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestCommitMessage(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &Keyring{}
+	if message := keys.CommitMessage("set", "credentials/gmail", "/password", "Update content at store/credentials/gmail"); message != "Update content at store/credentials/gmail" {
+		t.Error("Expecting the default message without template, got", message)
+	}
+	keys.MessageTemplate = "{operation} {path} {pointer} from {host}"
+	if message := keys.CommitMessage("set", "credentials/gmail", "/password", "Update content"); message != "set credentials/gmail /password from "+host {
+		t.Error("Unexpected message", message)
+	}
+	if message := keys.CommitMessage("remove", "credentials/gmail", "", "Remove content"); message != "remove credentials/gmail from "+host {
+		t.Error("Expecting no double space for an empty pointer, got", message)
+	}
+	if message := keys.CommitMessage("mv", "a", "", "Move content\n\nMoved: store/a -> store/b"); message != "mv a from "+host+"\n\nMoved: store/a -> store/b" {
+		t.Error("Expecting the trailers to be kept, got", message)
+	}
+	keys.Redact = []string{"bank", "*/pin"}
+	if message := keys.CommitMessage("set", "bank/visa", "/code", "Update content"); message != "set [redacted] [redacted] from "+host {
+		t.Error("Expecting the path and pointer to be redacted, got", message)
+	}
+	if message := keys.CommitMessage("set", "phone/pin", "", "Update content"); message != "set [redacted] from "+host {
+		t.Error("Expecting a glob to redact, got", message)
+	}
+	if IsRedacted("banking/visa", keys) {
+		t.Error("Expecting a pattern to match whole path elements only")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	keys := &Keyring{}
+	signature := keys.Signature(time.Now())
+	if signature.Name != AUTHOR_NAME || signature.Email != AUTHOR_EMAIL {
+		t.Error("Expecting the vstore author by default, got", signature)
+	}
+	keys.AuthorName, keys.AuthorEmail = "Alice", "alice@example.com"
+	commit := &object.Commit{Author: keys.Signature(time.Now())}
+	if !keys.IsAuthor(commit) || (&Keyring{}).IsAuthor(commit) {
+		t.Error("Expecting only alice to author the commit")
+	}
+}
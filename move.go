@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Moved and copied objects are sealed again for their new location, so git
+// can't detect the rename from the content. Commits record it in trailers,
+// "Moved: store/a -> store/b", between repository relative paths of the
+// content files. With encrypted names a moved object keeps its id and needs
+// no trailer. Redacted objects get no trailer either, their history isn't
+// followed across moves.
+const (
+	MOVED_TRAILER  = "Moved:"
+	COPIED_TRAILER = "Copied:"
+)
+
+func renameTrailer(kind string, from string, to string) string {
+	return fmt.Sprintf("%s %s -> %s", kind, filepath.ToSlash(from), filepath.ToSlash(to))
+}
+
+// objectsUnder returns the locations of the objects in the directory at
+// location.
+func objectsUnder(location string, keys *Keyring) ([]string, error) {
+	files, err := ListObjects(keys)
+	if err != nil {
+		return nil, err
+	}
+	locations := []string{}
+	for _, file := range files {
+		file = filepath.ToSlash(file)
+		if strings.HasPrefix(file, location+"/") {
+			locations = append(locations, file)
+		}
+	}
+	sort.Strings(locations)
+	return locations, nil
+}
+
+// IsObjectDir tells whether the store relative path name is a directory
+// holding objects rather than an object.
+func IsObjectDir(name string, keys *Keyring) (bool, error) {
+	location := strings.Trim(filepath.ToSlash(name), "/")
+	if location == "" {
+		return false, nil
+	}
+	storepath, err := GetStorePath()
+	if err != nil {
+		return false, err
+	}
+	exists, err := ObjectExists(filepath.Join(storepath, filepath.FromSlash(location)), keys)
+	if err != nil || exists {
+		return false, err
+	}
+	locations, err := objectsUnder(location, keys)
+	return len(locations) > 0, err
+}
+
+// recipientsDirsUnder returns the directories at or below location that have
+// recipients.
+func recipientsDirsUnder(location string, keys *Keyring) ([]string, error) {
+	index, err := keys.Index()
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		dirs := []string{}
+		for dir := range index.Recipients {
+			if location == "" || dir == location || strings.HasPrefix(dir, location+"/") {
+				dirs = append(dirs, dir)
+			}
+		}
+		sort.Strings(dirs)
+		return dirs, nil
+	}
+	storepath, err := GetStorePath()
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{}
+	root := filepath.Join(storepath, filepath.FromSlash(location))
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && info.Name() == RECIPIENTS_FILE {
+			dir, err := ObjectLocation(filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// StoreMoveObject moves the object or the directory at path to the store
+// relative path target in a single commit.
+func StoreMoveObject(path string, target string, keys *Keyring) error {
+	return storeTransfer(path, target, false, keys)
+}
+
+// StoreCopyObject copies the object or the directory at path to the store
+// relative path target in a single commit.
+func StoreCopyObject(path string, target string, keys *Keyring) error {
+	return storeTransfer(path, target, true, keys)
+}
+
+func storeTransfer(path string, target string, copy bool, keys *Keyring) error {
+	dirty, err := DirtyFiles()
+	if err != nil {
+		return err
+	}
+	err = transferObjects(path, target, copy, keys)
+	if err != nil {
+		return rollbackFiles(dirty, keys, err)
+	}
+	return nil
+}
+
+// transferObjects writes every target before removing any source, and
+// commits.
+func transferObjects(path string, target string, copy bool, keys *Keyring) error {
+	storepath, err := GetStorePath()
+	if err != nil {
+		return err
+	}
+	location, err := ObjectLocation(path)
+	if err != nil {
+		return err
+	}
+	location = strings.Trim(location, "/")
+	targetLocation := strings.Trim(filepath.ToSlash(filepath.Clean(target)), "/")
+	if targetLocation == "" || targetLocation == "." || strings.HasPrefix(targetLocation, "../") {
+		return fmt.Errorf("%v is not a path in the store", target)
+	}
+	if targetLocation == location || strings.HasPrefix(targetLocation, location+"/") {
+		return fmt.Errorf("can't move or copy %v into itself", location)
+	}
+	// map every object to its new location
+	exists, err := ObjectExists(path, keys)
+	if err != nil {
+		return err
+	}
+	isDir := !exists
+	sources := []string{location}
+	if isDir {
+		sources, err = objectsUnder(location, keys)
+		if err != nil {
+			return err
+		}
+		if len(sources) == 0 {
+			return fmt.Errorf("nothing to move at %v", location)
+		}
+	}
+	targets := map[string]string{}
+	for _, source := range sources {
+		newLocation := targetLocation + strings.TrimPrefix(source, location)
+		exists, err := ObjectExists(filepath.Join(storepath, filepath.FromSlash(newLocation)), keys)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%v already exists", newLocation)
+		}
+		targets[source] = newLocation
+	}
+	// read everything with the current recipients before changing them
+	contents := map[string][]byte{}
+	for _, source := range sources {
+		rawjson, err := GetRawJsonContent(filepath.Join(storepath, filepath.FromSlash(source)), keys)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't read content file at path %v", source))
+			return err
+		}
+		contents[source] = rawjson
+	}
+	paths := []string{}
+	oldRecipients := []string{}
+	oldSources := []string{}
+	// recipients files move along with their directory
+	if isDir {
+		dirs, err := recipientsDirsUnder(location, keys)
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			newDir := targetLocation + strings.TrimPrefix(dir, location)
+			existing, err := readRecipientsFile(newDir, keys)
+			if err != nil {
+				return err
+			}
+			if existing != nil {
+				return fmt.Errorf("%v already has recipients", newDir)
+			}
+			recipients, err := readRecipientsFile(dir, keys)
+			if err != nil {
+				return err
+			}
+			written, err := writeRecipientsFile(newDir, recipients, keys)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, written)
+			if !copy {
+				oldRecipients = append(oldRecipients, dir)
+			}
+		}
+	}
+	index, err := keys.Index()
+	if err != nil {
+		return err
+	}
+	kind := MOVED_TRAILER
+	if copy {
+		kind = COPIED_TRAILER
+	}
+	trailers := []string{}
+	for _, source := range sources {
+		oldpath := filepath.Join(storepath, filepath.FromSlash(source))
+		newpath := filepath.Join(storepath, filepath.FromSlash(targets[source]))
+		from, err := PhysicalPath(oldpath, keys, false)
+		if err != nil {
+			return err
+		}
+		if !copy && index != nil {
+			// keep the id, only the index entry changes
+			index.Ids[targets[source]] = index.Ids[source]
+			delete(index.Ids, source)
+			index.dirty = true
+		}
+		err = WriteRawJsonContent(newpath, contents[source], keys)
+		if err != nil {
+			return err
+		}
+		if !copy && index == nil {
+			oldSources = append(oldSources, oldpath)
+		}
+		added, err := PhysicalPath(newpath, keys, false)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, added)
+		redacted := IsRedacted(source, keys) || IsRedacted(targets[source], keys)
+		if from != added && !redacted {
+			fromRel, err := RepoRelPath(from)
+			if err != nil {
+				return err
+			}
+			toRel, err := RepoRelPath(added)
+			if err != nil {
+				return err
+			}
+			trailers = append(trailers, renameTrailer(kind, fromRel, toRel))
+		}
+	}
+	// the sources go once every target is written
+	for _, dir := range oldRecipients {
+		removed, err := writeRecipientsFile(dir, nil, keys)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, removed)
+	}
+	for _, old := range oldSources {
+		physical, err := RemoveObject(old, keys)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, physical)
+	}
+	if !copy && index == nil {
+		removeEmptyDirs(storepath)
+	}
+	verb := "Move"
+	if copy {
+		verb = "Copy"
+	}
+	message := fmt.Sprintf("%s content from %s to %s", verb, STORE_FOLDER_NAME+"/"+location, STORE_FOLDER_NAME+"/"+targetLocation)
+	if IsRedacted(location, keys) || IsRedacted(targetLocation, keys) {
+		message = fmt.Sprintf("%s content", verb)
+	}
+	operation := "mv"
+	if copy {
+		operation = "cp"
+	}
+	// the template shows [redacted] when either side is
+	templated := location
+	if IsRedacted(targetLocation, keys) {
+		templated = targetLocation
+	}
+	message = keys.CommitMessage(operation, templated, "", message)
+	if len(trailers) > 0 {
+		message += "\n\n" + strings.Join(trailers, "\n")
+	}
+	return StoreCommit(paths, message, keys)
+}
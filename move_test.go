@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObjectsUnder(t *testing.T) {
+	keys := testKeyring(t)
+	keys.index = &objectindex{Ids: map[string]string{
+		"credentials/gmail":     "1",
+		"credentials/team/wifi": "2",
+		"credentials-old/gmail": "3",
+		"notes":                 "4",
+	}}
+	locations, err := objectsUnder("credentials", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"credentials/gmail", "credentials/team/wifi"}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Error("Expecting", expected, "got", locations)
+	}
+	for name, expected := range map[string]bool{"credentials": true, "credentials/team/": true, "cred": false, "notes": false} {
+		isDir, err := IsObjectDir(name, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isDir != expected {
+			t.Error("Expecting IsObjectDir", name, "to be", expected)
+		}
+	}
+}
+
+func TestRenameTrailer(t *testing.T) {
+	trailer := renameTrailer(MOVED_TRAILER, "store/credentials/gmail", "store/personal/google")
+	if trailer != "Moved: store/credentials/gmail -> store/personal/google" {
+		t.Error("Unexpected trailer", trailer)
+	}
+}
+
+func TestMoveRedactedObject(t *testing.T) {
+	storepath := testStore(t)
+	keys := testKeyring(t)
+	keys.Redact = []string{"bank"}
+	keys.MessageTemplate = "{operation} {path}"
+	err := StoreSetValue(filepath.Join(storepath, "cards/visa"), "/code", "1234", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StoreMoveObject(filepath.Join(storepath, "cards/visa"), "bank/visa", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(commit.Message, "bank") || strings.Contains(commit.Message, MOVED_TRAILER) {
+		t.Error("Expecting no redacted path in the message, got", commit.Message)
+	}
+}
+
+func TestMoveFailureRestoresWorktree(t *testing.T) {
+	storepath := testStore(t)
+	keys := testKeyring(t)
+	identity, alice := testRecipient(t, "alice")
+	keys.Identity = identity
+	approve := RecipientApprover
+	defer func() { RecipientApprover = approve }()
+	RecipientApprover = func(string, recipient) bool { return true }
+	written, err := writeRecipientsFile("team", []recipient{alice}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StoreSetValue(filepath.Join(storepath, "team/wifi"), "/pass", "x", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StoreCommit([]string{written}, "Share team", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// writing the first target fails once alice is no longer known
+	known, err := GetKnownRecipientsPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Remove(known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RecipientApprover = func(string, recipient) bool { return false }
+	err = StoreMoveObject(filepath.Join(storepath, "team"), "crew", keys)
+	if err == nil {
+		t.Fatal("Expecting the move to fail")
+	}
+	dirty, err := DirtyFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirty) != 0 {
+		t.Error("Expecting the worktree to match HEAD after a failed move, got", dirty)
+	}
+	RecipientApprover = func(string, recipient) bool { return true }
+	err = StoreMoveObject(filepath.Join(storepath, "team"), "crew", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := ObjectExists(filepath.Join(storepath, "crew/wifi"), keys)
+	if err != nil || !moved {
+		t.Error("Expecting the directory to move once its recipients are approved, got", moved, err)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// With encrypted names, content files are stored under random ids directly in
+// the store folder and an index sealed under the store key maps their logical
+// paths to ids. The rest of vstore keeps working with logical paths,
+// store/credentials/gmail, which are only translated to the file holding the
+// content when reading, writing and committing.
+const (
+	STORE_CONFIG_FILE = "config.json"
+	INDEX_FILE        = "index"
+	INDEX_LOCATION    = META_FOLDER_NAME + "/" + INDEX_FILE
+	OBJECT_ID_BYTES   = 16
+)
+
+// storeconfig holds the options shared by everyone using the store, it is
+// committed in plain text.
+type storeconfig struct {
+	EncryptedNames bool `json:"encrypted_names"`
+}
+
+type objectindex struct {
+	// Ids maps logical paths relative to the store to object ids.
+	Ids map[string]string `json:"ids"`
+	// Recipients maps shared directories to their recipients, listed as in
+	// a recipients file.
+	Recipients map[string]string `json:"recipients,omitempty"`
+	dirty      bool
+}
+
+func GetStoreConfigPath() (string, error) {
+	path, err := GetMetaPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, STORE_CONFIG_FILE), nil
+}
+
+func GetIndexPath() (string, error) {
+	path, err := GetMetaPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, INDEX_FILE), nil
+}
+
+func GetStoreConfig() (storeconfig, error) {
+	var config storeconfig
+	path, err := GetStoreConfigPath()
+	if err != nil {
+		return config, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		HandleErr(err, fmt.Sprintf("Couldn't read store config at path %v", path))
+		return config, err
+	}
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		HandleErr(err, "Couldn't read store config as JSON object")
+	}
+	return config, err
+}
+
+func WriteStoreConfig(config storeconfig) (string, error) {
+	path, err := GetStoreConfigPath()
+	if err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = CreateMetaDir()
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(path, b, 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write store config at path %v", path))
+	}
+	return path, err
+}
+
+// NamesEncrypted tells whether the store keeps its file names in the index.
+func NamesEncrypted() bool {
+	config, err := GetStoreConfig()
+	return err == nil && config.EncryptedNames
+}
+
+// Index returns the object index, loading it on first use. It is nil when the
+// store doesn't encrypt names.
+func (keys *Keyring) Index() (*objectindex, error) {
+	if keys.index != nil || !NamesEncrypted() {
+		return keys.index, nil
+	}
+	index := &objectindex{Ids: map[string]string{}}
+	path, err := GetIndexPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		HandleErr(err, fmt.Sprintf("Couldn't read index at path %v", path))
+		return nil, err
+	}
+	if err == nil {
+		raw, err := keys.openShared(b, INDEX_LOCATION, false)
+		if err != nil {
+			HandleErr(err, "Couldn't decrypt the index")
+			return nil, err
+		}
+		err = json.Unmarshal(raw, index)
+		if err != nil {
+			HandleErr(err, "Couldn't read the index as JSON object")
+			return nil, err
+		}
+	}
+	keys.index = index
+	return index, nil
+}
+
+// SaveIndex seals and writes the index when it changed. It returns the index
+// path, or an empty string when there was nothing to write.
+func SaveIndex(keys *Keyring) (string, error) {
+	index, err := keys.Index()
+	if err != nil || index == nil || !index.dirty {
+		return "", err
+	}
+	b, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := keys.Seal(b, INDEX_LOCATION)
+	if err != nil {
+		return "", err
+	}
+	path, err := GetIndexPath()
+	if err != nil {
+		return "", err
+	}
+	err = CreateMetaDir()
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(path, sealed, 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write index at path %v", path))
+		return "", err
+	}
+	index.dirty = false
+	return path, nil
+}
+
+func GenerateObjectId() (string, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt[:OBJECT_ID_BYTES]), nil
+}
+
+// PhysicalPath returns the file holding the content at the logical path. Paths
+// outside of the store are returned as is. When names are encrypted and path
+// isn't in the index, a new id is allocated if create is set, otherwise the
+// returned error satisfies os.IsNotExist.
+func PhysicalPath(path string, keys *Keyring, create bool) (string, error) {
+	index, err := keys.Index()
+	if err != nil || index == nil {
+		return path, err
+	}
+	storepath, err := GetStorePath()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(path, storepath+string(filepath.Separator)) {
+		return path, nil
+	}
+	location, err := ObjectLocation(path)
+	if err != nil {
+		return "", err
+	}
+	id, ok := index.Ids[location]
+	if !ok {
+		if !create {
+			return "", &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		id, err = GenerateObjectId()
+		if err != nil {
+			return "", err
+		}
+		index.Ids[location] = id
+		index.dirty = true
+	}
+	return filepath.Join(storepath, id), nil
+}
+
+// ObjectExists tells whether there is content at the logical path.
+func ObjectExists(path string, keys *Keyring) (bool, error) {
+	physical, err := PhysicalPath(path, keys, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	info, err := os.Stat(physical)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// a directory of objects is not one
+	return !info.IsDir(), nil
+}
+
+// RemoveObject deletes the content at the logical path and returns the path
+// of the deleted file.
+func RemoveObject(path string, keys *Keyring) (string, error) {
+	physical, err := PhysicalPath(path, keys, false)
+	if err != nil {
+		return "", err
+	}
+	err = os.Remove(physical)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't remove at path %v", path))
+		return "", err
+	}
+	index, err := keys.Index()
+	if err != nil {
+		return "", err
+	}
+	if index != nil {
+		location, err := ObjectLocation(path)
+		if err != nil {
+			return "", err
+		}
+		delete(index.Ids, location)
+		index.dirty = true
+	}
+	return physical, nil
+}
+
+// ListObjects returns the logical paths of all content files, relative to the
+// store.
+func ListObjects(keys *Keyring) ([]string, error) {
+	index, err := keys.Index()
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		storepath, err := GetStorePath()
+		if err != nil {
+			return nil, err
+		}
+		exists, _ := PathExists(storepath)
+		if !exists {
+			return []string{}, nil
+		}
+		return FilePathWalkDir(storepath)
+	}
+	files := make([]string, 0, len(index.Ids))
+	for location := range index.Ids {
+		files = append(files, filepath.FromSlash(location))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// EncryptNames moves every content file of the store under a random id and
+// records its path in the index, along with the recipients files. The content
+// doesn't need to be sealed again: it is bound to its logical path, which
+// doesn't change.
+func EncryptNames(keys *Keyring) error {
+	if NamesEncrypted() {
+		return errors.New("file names are already encrypted")
+	}
+	storepath, err := GetStorePath()
+	if err != nil {
+		return err
+	}
+	files, err := ListObjects(keys)
+	if err != nil {
+		return err
+	}
+	// make sure the store key exists before switching modes
+	_, err = keys.StoreKey(true)
+	if err != nil {
+		return err
+	}
+	dirs, err := recipientsDirsUnder("", keys)
+	if err != nil {
+		return err
+	}
+	index := &objectindex{Ids: map[string]string{}, Recipients: map[string]string{}, dirty: true}
+	paths := []string{}
+	for _, dir := range dirs {
+		recipients, err := readRecipientsFile(dir, keys)
+		if err != nil {
+			return err
+		}
+		index.Recipients[dir] = string(formatRecipients(recipients))
+		path, err := getRecipientsPath(dir)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(path)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't remove recipients file at path %v", path))
+			return err
+		}
+		paths = append(paths, path)
+	}
+	for _, file := range files {
+		id, err := GenerateObjectId()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(storepath, file)
+		err = os.Rename(path, filepath.Join(storepath, id))
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't move %v to %v", file, id))
+			return err
+		}
+		index.Ids[filepath.ToSlash(file)] = id
+		paths = append(paths, path, filepath.Join(storepath, id))
+	}
+	configPath, err := WriteStoreConfig(storeconfig{EncryptedNames: true})
+	if err != nil {
+		return err
+	}
+	keys.index = index
+	indexPath, err := SaveIndex(keys)
+	if err != nil {
+		return err
+	}
+	paths = append(paths, configPath, indexPath)
+	removeEmptyDirs(storepath)
+	fmt.Printf("Moved %d files under encrypted names\n", len(files))
+	return StoreCommit(paths, "Encrypt file names", keys)
+}
+
+// removeEmptyDirs removes the directories left empty under root, keeping root.
+func removeEmptyDirs(root string) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != ".git" {
+			path := filepath.Join(root, entry.Name())
+			removeEmptyDirs(path)
+			// fails when the directory isn't empty
+			os.Remove(path)
+		}
+	}
+}
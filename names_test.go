@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPhysicalPath(t *testing.T) {
+	keys := testKeyring(t)
+	keys.index = &objectindex{Ids: map[string]string{}}
+	storepath, err := GetStorePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(storepath, "credentials", "gmail")
+	_, err = PhysicalPath(path, keys, false)
+	if !os.IsNotExist(err) {
+		t.Error("Expecting a not exist error for a path missing from the index, got", err)
+	}
+	physical, err := PhysicalPath(path, keys, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := keys.index.Ids["credentials/gmail"]
+	if len(id) != 2*OBJECT_ID_BYTES || physical != filepath.Join(storepath, id) {
+		t.Error("Expecting the path to be allocated a random id, got", physical)
+	}
+	if !keys.index.dirty {
+		t.Error("Expecting the index to be marked as changed")
+	}
+	again, err := PhysicalPath(path, keys, false)
+	if err != nil || again != physical {
+		t.Error("Expecting the same id for the same path, got", again, err)
+	}
+	keyPath, err := GetStoreKeyPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := PhysicalPath(keyPath, keys, false)
+	if err != nil || outside != keyPath {
+		t.Error("Expecting paths outside of the store to be kept, got", outside, err)
+	}
+}
+
+func TestEncryptNamesKeepsRecipientsInIndex(t *testing.T) {
+	storepath := testStore(t)
+	keys := testKeyring(t)
+	identity, alice := testRecipient(t, "alice")
+	keys.Identity = identity
+	approve := RecipientApprover
+	defer func() { RecipientApprover = approve }()
+	RecipientApprover = func(string, recipient) bool { return true }
+	recipientsPath, err := writeRecipientsFile("team", []recipient{alice}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StoreCommit([]string{recipientsPath}, "Share team", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wifi := filepath.Join(storepath, "team", "wifi")
+	err = StoreSetValue(wifi, "/pass", "x", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = EncryptNames(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := PathExists(recipientsPath); exists {
+		t.Error("Expecting the recipients file to be removed along with the directory name")
+	}
+	// read back from the committed index
+	keys.index = nil
+	recipients, err := readRecipientsFile("team", keys)
+	if err != nil || len(recipients) != 1 || recipients[0].Name != "alice" {
+		t.Error("Expecting the recipients to be kept in the index, got", recipients, err)
+	}
+	doc, err := GetJsonContent(wifi, keys)
+	if err != nil || doc["pass"] != "x" {
+		t.Error("Expecting the shared object to stay readable, got", doc, err)
+	}
+	dir, ok, err := recipientsDir("team/other", keys)
+	if err != nil || !ok || dir != "team" {
+		t.Error("Expecting team to have recipients, got", dir, ok, err)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Commits always land in the local repository first. The commits of the local
+// branch missing from the remote tracking branch are the pending pushes, they
+// are pushed with the next commit or by sync. Offline, vstore doesn't touch
+// the network at all.
+const (
+	OFFLINE_FLAG = "--offline"
+	OFFLINE_ENV  = "VSTORE_OFFLINE"
+)
+
+// Offline is set when vstore must not reach the remote.
+var Offline bool
+
+// OfflineRequested removes the offline flag from args and tells whether the
+// flag or the environment asks to work offline.
+func OfflineRequested(args []string) ([]string, bool) {
+	env := strings.ToLower(os.Getenv(OFFLINE_ENV))
+	offline := env != "" && env != "0" && env != "false"
+	kept := []string{}
+	for _, arg := range args {
+		if arg == OFFLINE_FLAG {
+			offline = true
+		} else {
+			kept = append(kept, arg)
+		}
+	}
+	return kept, offline
+}
+
+// commitsOnlyIn returns the commits reachable from from and not from other,
+// newest first.
+func commitsOnlyIn(repo *git.Repository, from plumbing.Hash, other plumbing.Hash) ([]*object.Commit, error) {
+	excluded := map[plumbing.Hash]bool{}
+	if !other.IsZero() {
+		commits, err := repo.Log(&git.LogOptions{From: other})
+		if err != nil {
+			return nil, err
+		}
+		err = commits.ForEach(func(commit *object.Commit) error {
+			excluded[commit.Hash] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	only := []*object.Commit{}
+	commits, err := repo.Log(&git.LogOptions{From: from, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, err
+	}
+	err = commits.ForEach(func(commit *object.Commit) error {
+		if !excluded[commit.Hash] {
+			only = append(only, commit)
+		}
+		return nil
+	})
+	return only, err
+}
+
+// PendingCommits returns the local commits not pushed yet to the remote name
+// and its commits not merged yet, as of the last fetch or push.
+func PendingCommits(repo *git.Repository, name string) ([]*object.Commit, []*object.Commit, error) {
+	head, err := repo.Head()
+	if err != nil {
+		HandleErr(err, "Couldn't get the repository head")
+		return nil, nil, err
+	}
+	remote := plumbing.ZeroHash
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(name, head.Name().Short()), true)
+	if err == nil {
+		remote = remoteRef.Hash()
+	} else if err != plumbing.ErrReferenceNotFound {
+		return nil, nil, err
+	}
+	ahead, err := commitsOnlyIn(repo, head.Hash(), remote)
+	if err != nil {
+		return nil, nil, err
+	}
+	behind := []*object.Commit{}
+	if !remote.IsZero() {
+		behind, err = commitsOnlyIn(repo, remote, head.Hash())
+	}
+	return ahead, behind, err
+}
+
+// StoreStatus prints how far ahead and behind origin the store is, and the
+// commits waiting to be pushed. Online, origin is fetched first. Mirrors are
+// only compared as of their last push.
+func StoreStatus(keys *Keyring) error {
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	if len(keys.Remotes) == 0 {
+		fmt.Println("Local store, add a remote with vstore remote add <url>")
+		return nil
+	}
+	if HasRemote(repo) {
+		asOf := "now"
+		if Offline {
+			asOf = "as of the last sync"
+		} else {
+			auth, err := keys.RepoAuth(repo)
+			if err == nil {
+				err = repo.Fetch(&git.FetchOptions{RemoteName: REMOTE_NAME, Auth: auth})
+			}
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				fmt.Println("Couldn't reach the remote:", err)
+				asOf = "as of the last sync"
+			}
+		}
+		ahead, behind, err := PendingCommits(repo, REMOTE_NAME)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d commits to push, %d commits to pull, %s\n", len(ahead), len(behind), asOf)
+		for _, commit := range ahead {
+			printCommit(commit, "")
+		}
+	}
+	for _, remote := range keys.Remotes {
+		if remote.Name == REMOTE_NAME {
+			continue
+		}
+		ahead, _, err := PendingCommits(repo, remote.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d commits to push, pushed %s\n", remote.Name, len(ahead), remote.Push)
+	}
+	return nil
+}
+
+// StoreSync pulls and merges the changes of origin, then pushes the pending
+// commits to every remote, whatever their push policy. A failing remote
+// doesn't stop the others.
+func StoreSync(keys *Keyring) error {
+	if Offline {
+		return errors.New("can't sync offline")
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	if len(keys.Remotes) == 0 {
+		return errors.New("no remote to sync with, add one with vstore remote add <url>")
+	}
+	if HasRemote(repo) {
+		err = StoreMerge(keys)
+		if err != nil {
+			HandleErr(err, "Couldn't merge the remote changes")
+			return err
+		}
+	}
+	failed := []string{}
+	for _, remote := range keys.Remotes {
+		err = ensureGitRemote(repo, remote)
+		if err != nil {
+			return err
+		}
+		ahead, _, err := PendingCommits(repo, remote.Name)
+		if err != nil {
+			return err
+		}
+		if len(ahead) == 0 {
+			fmt.Printf("%s: already in sync\n", remote.Name)
+			continue
+		}
+		err = pushRemote(repo, remote, keys)
+		if err != nil {
+			fmt.Printf("%s: couldn't push: %v\n", remote.Name, err)
+			failed = append(failed, remote.Name)
+			continue
+		}
+		fmt.Printf("%s: pushed %d commits\n", remote.Name, len(ahead))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("couldn't push to %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
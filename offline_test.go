@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestOfflineRequested(t *testing.T) {
+	os.Setenv(OFFLINE_ENV, "")
+	defer os.Unsetenv(OFFLINE_ENV)
+	args, offline := OfflineRequested([]string{"set", OFFLINE_FLAG, "notes", "/a"})
+	if !offline || !reflect.DeepEqual(args, []string{"set", "notes", "/a"}) {
+		t.Error("Expecting the flag to be removed and offline set, got", args, offline)
+	}
+	_, offline = OfflineRequested([]string{"ls"})
+	if offline {
+		t.Error("Expecting to be online by default")
+	}
+	os.Setenv(OFFLINE_ENV, "1")
+	_, offline = OfflineRequested([]string{"ls"})
+	if !offline {
+		t.Error("Expecting the environment to set offline")
+	}
+}
+
+func TestCommitsOnlyIn(t *testing.T) {
+	repo, commitFiles := testRepo(t)
+	commit := func(content string) plumbing.Hash {
+		return commitFiles(content, map[string]string{"store/a": content}, nil, nil)
+	}
+	commit("1")
+	pushed := commit("2")
+	commit("3")
+	head := commit("4")
+	ahead, err := commitsOnlyIn(repo, head, pushed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ahead) != 2 || ahead[0].Message != "4" || ahead[1].Message != "3" {
+		t.Error("Expecting the two unpushed commits, got", ahead)
+	}
+	all, err := commitsOnlyIn(repo, head, plumbing.ZeroHash)
+	if err != nil || len(all) != 4 {
+		t.Error("Expecting every commit without a remote, got", len(all), err)
+	}
+}
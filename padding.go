@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Padding schemes hide the length of the content. Padded content is the
+// content, a 0x80 marker byte then zeros up to the length chosen by the
+// scheme.
+const (
+	PADDING_NONE  = 0
+	PADDING_PADME = 1
+	PADDING_POW2  = 2
+
+	PADDING_POW2_MIN = 64
+)
+
+var paddingNames = map[string]byte{
+	"none":  PADDING_NONE,
+	"padme": PADDING_PADME,
+	"pow2":  PADDING_POW2,
+}
+
+// ParsePadding returns the padding scheme for its settings name, padmé when
+// name is empty.
+func ParsePadding(name string) (byte, error) {
+	if name == "" {
+		return PADDING_PADME, nil
+	}
+	padding, ok := paddingNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown padding scheme %v", name)
+	}
+	return padding, nil
+}
+
+// padmeLength rounds length up so that at most 12% is added and only
+// O(log log length) bits of the length are leaked, see
+// https://lbarman.ch/blog/padme/
+func padmeLength(length int) int {
+	if length < 2 {
+		return length
+	}
+	e := bits.Len(uint(length)) - 1
+	s := bits.Len(uint(e))
+	mask := 1<<uint(e-s) - 1
+	return (length + mask) &^ mask
+}
+
+func pow2Length(length int) int {
+	padded := PADDING_POW2_MIN
+	for padded < length {
+		padded *= 2
+	}
+	return padded
+}
+
+// Pad pads content with the given scheme.
+func Pad(content []byte, padding byte) ([]byte, error) {
+	var length int
+	switch padding {
+	case PADDING_NONE:
+		return content, nil
+	case PADDING_PADME:
+		length = padmeLength(len(content) + 1)
+	case PADDING_POW2:
+		length = pow2Length(len(content) + 1)
+	default:
+		return nil, fmt.Errorf("unknown padding scheme %d", padding)
+	}
+	padded := make([]byte, length)
+	copy(padded, content)
+	padded[len(content)] = 0x80
+	return padded, nil
+}
+
+// Unpad strips the padding added by Pad.
+func Unpad(padded []byte, padding byte) ([]byte, error) {
+	switch padding {
+	case PADDING_NONE:
+		return padded, nil
+	case PADDING_PADME, PADDING_POW2:
+		i := len(padded) - 1
+		for i >= 0 && padded[i] == 0 {
+			i--
+		}
+		if i < 0 || padded[i] != 0x80 {
+			return nil, errors.New("malformed padding")
+		}
+		return padded[:i], nil
+	}
+	return nil, fmt.Errorf("unknown padding scheme %d", padding)
+}
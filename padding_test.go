@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPadmeLength(t *testing.T) {
+	for length, expected := range map[int]int{1: 1, 9: 10, 33: 36, 100: 104, 1000: 1024, 1025: 1088} {
+		if padded := padmeLength(length); padded != expected {
+			t.Error("Expecting padme length of", length, "to be", expected, "got", padded)
+		}
+	}
+}
+
+func TestPadUnpad(t *testing.T) {
+	for _, padding := range []byte{PADDING_NONE, PADDING_PADME, PADDING_POW2} {
+		for _, content := range [][]byte{{}, []byte("{\"password\":\"gke94dsFVs\"}"), bytes.Repeat([]byte{0x80, 0}, 300)} {
+			padded, err := Pad(content, padding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if padding == PADDING_POW2 && len(padded)&(len(padded)-1) != 0 {
+				t.Error("Expecting a power of two length, got", len(padded))
+			}
+			unpadded, err := Unpad(padded, padding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unpadded, content) {
+				t.Error("Expecting content to round trip with padding", padding)
+			}
+		}
+	}
+	short, _ := Pad(bytes.Repeat([]byte("a"), 99), PADDING_PADME)
+	long, _ := Pad(bytes.Repeat([]byte("a"), 102), PADDING_PADME)
+	if len(short) != len(long) {
+		t.Error("Expecting close lengths to be padded to the same bucket", len(short), len(long))
+	}
+}
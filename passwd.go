@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// ReadNewSecret prompts twice for a new secret and checks both entries match.
+func ReadNewSecret(name string) (string, error) {
+	var secret, confirm string
+	fmt.Printf("new %s: ", name)
+	fmt.Scanln(&secret)
+	fmt.Printf("confirm new %s: ", name)
+	fmt.Scanln(&confirm)
+	if secret == "" {
+		return "", fmt.Errorf("the new %s can't be empty", name)
+	}
+	if secret != confirm {
+		return "", fmt.Errorf("the %s entries don't match", name)
+	}
+	return secret, nil
+}
+
+// ChangePassword re-encrypts the settings file with a new local password.
+func ChangePassword(settings usersettings) error {
+	password, err := ReadNewSecret("password")
+	if err != nil {
+		HandleErr(err, "Couldn't read the new password")
+		return err
+	}
+	err = CreateEncodedSettingsFile(password, settings)
+	if err != nil {
+		HandleErr(err, "Couldn't re-encrypt the settings file")
+		return err
+	}
+	fmt.Println("Password changed, use the new one in VSTORE_PASSWORD")
+	return nil
+}
+
+// sealedWithMasterKey tells whether the content file data is opened with the
+// master key itself rather than with the store key or an identity.
+func sealedWithMasterKey(data []byte) bool {
+	if IsAgeFile(data) {
+		return IsAgePassphraseFile(data)
+	}
+	kdf, err := EnvelopeKdf(data)
+	return err != nil || (kdf.Id != KDF_HKDF_STORE_KEY && kdf.Id != KDF_X25519_RECIPIENTS)
+}
+
+// RotateMasterKey replaces the master key. The store key is kept, so history
+// stays readable, and its file is wrapped again with the new master key. The
+// files still sealed with the master key itself are re-encrypted under the
+// store key. Everything is pushed as a single commit. The new master key is
+// only saved once every file is written, and the files are put back as they
+// are at HEAD when anything fails.
+func RotateMasterKey(password string, settings usersettings, keys *Keyring) error {
+	masterKey, err := ReadNewSecret("master key")
+	if err != nil {
+		HandleErr(err, "Couldn't read the new master key")
+		return err
+	}
+	if masterKey == settings.MasterKey {
+		return errors.New("the new master key is the same as the current one")
+	}
+	storepath, err := GetStorePath()
+	if err != nil {
+		return err
+	}
+	files, err := ListObjects(keys)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't list files at path %v", storepath))
+		return err
+	}
+	// decrypt everything before writing anything
+	sealed := []string{}
+	contents := [][]byte{}
+	for _, file := range files {
+		path := filepath.Join(storepath, file)
+		physical, err := PhysicalPath(path, keys, false)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(physical)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't read file %v", file))
+			return err
+		}
+		if !sealedWithMasterKey(b) {
+			continue
+		}
+		content, err := GetRawJsonContent(path, keys)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't decrypt file %v", file))
+			return err
+		}
+		sealed = append(sealed, file)
+		contents = append(contents, content)
+	}
+	// files are restored from HEAD and the old settings kept on any error,
+	// the new master key is saved only once everything is written
+	dirty, err := DirtyFiles()
+	if err != nil {
+		return err
+	}
+	// a store of files sealed with the master key only gets its store key now
+	storeKey, err := keys.StoreKey(true)
+	if err != nil {
+		return rollbackFiles(dirty, keys, err)
+	}
+	newKeys := *keys
+	newKeys.MasterKey = masterKey
+	newKeys.Kdf = settings.Kdf
+	newKeys.storeKey = storeKey
+	paths, err := rewriteStore(storepath, sealed, contents, &newKeys)
+	if err != nil {
+		return rollbackFiles(dirty, keys, err)
+	}
+	oldMasterKey := settings.MasterKey
+	settings.MasterKey = masterKey
+	err = CreateEncodedSettingsFile(password, settings)
+	if err != nil {
+		HandleErr(err, "Couldn't save the new master key in the settings")
+		return rollbackFiles(dirty, keys, err)
+	}
+	err = StoreCommit(paths, "Rotate master key", &newKeys)
+	if err != nil {
+		settings.MasterKey = oldMasterKey
+		restoreErr := CreateEncodedSettingsFile(password, settings)
+		if restoreErr != nil {
+			HandleErr(restoreErr, fmt.Sprintf("Couldn't restore the old master key, the new one is %v", masterKey))
+		}
+		return rollbackFiles(dirty, keys, err)
+	}
+	fmt.Printf("Wrapped the store key with the new master key, re-encrypted %d files\n", len(sealed))
+	return nil
+}
+
+// rewriteStore writes the store key file and the given files with newKeys and
+// returns the written paths.
+func rewriteStore(storepath string, files []string, contents [][]byte, newKeys *Keyring) ([]string, error) {
+	keyPath, err := WriteStoreKey(newKeys.storeKey, newKeys.MasterKey, newKeys.Kdf)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{keyPath}
+	for i, file := range files {
+		path := filepath.Join(storepath, file)
+		err = WriteRawJsonContent(path, contents[i], newKeys)
+		if err != nil {
+			return nil, err
+		}
+		physical, err := PhysicalPath(path, newKeys, false)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, physical)
+	}
+	return paths, nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A patch document is either a JSON Patch, RFC 6902, when it is an array of
+// operations, or a JSON Merge Patch, RFC 7396, when it is an object. Patches
+// are applied to a copy of the document: if any operation fails nothing is
+// written.
+
+type patchop struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from"`
+	// Value stays raw to tell a missing value from null
+	Value json.RawMessage `json:"value"`
+}
+
+// value returns the decoded value of op, which add, replace and test require.
+func (op patchop) value() (interface{}, error) {
+	if op.Value == nil {
+		return nil, errors.New("missing value")
+	}
+	var value interface{}
+	err := UnmarshalJson(op.Value, &value)
+	return value, err
+}
+
+// pointerTokens splits a JSON pointer in its decoded reference tokens.
+func pointerTokens(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("%v is not a valid JSON pointer", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, appending bool) (int, error) {
+	if appending && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %v", token)
+	}
+	max := length - 1
+	if appending {
+		max = length
+	}
+	if i > max {
+		return 0, fmt.Errorf("array index %v out of bounds", token)
+	}
+	return i, nil
+}
+
+// pointerGet returns the value at the pointer tokens in doc.
+func pointerGet(doc interface{}, tokens []string) (interface{}, error) {
+	node := doc
+	for _, token := range tokens {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			child, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("object has no key %v", token)
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(v), false)
+			if err != nil {
+				return nil, err
+			}
+			node = v[i]
+		default:
+			return nil, fmt.Errorf("can't reference %v in a scalar value", token)
+		}
+	}
+	return node, nil
+}
+
+// pointerUpdate calls update on the container holding the last token and
+// replaces the container with its result, arrays change length in place.
+func pointerUpdate(doc interface{}, tokens []string, update func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("can't update the document root")
+	}
+	parent, err := pointerGet(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := update(parent, tokens[len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return updated, nil
+	}
+	grandparent, _ := pointerGet(doc, tokens[:len(tokens)-2])
+	token := tokens[len(tokens)-2]
+	switch v := grandparent.(type) {
+	case map[string]interface{}:
+		v[token] = updated
+	case []interface{}:
+		i, _ := arrayIndex(token, len(v), false)
+		v[i] = updated
+	}
+	return doc, nil
+}
+
+func patchAdd(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return pointerUpdate(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			v[token] = value
+			return v, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(v), true)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, nil)
+			copy(v[i+1:], v[i:])
+			v[i] = value
+			return v, nil
+		}
+		return nil, fmt.Errorf("can't add %v to a scalar value", token)
+	})
+}
+
+func patchRemove(doc interface{}, tokens []string) (interface{}, error) {
+	return pointerUpdate(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := v[token]; !ok {
+				return nil, fmt.Errorf("object has no key %v", token)
+			}
+			delete(v, token)
+			return v, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(v), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(v[:i], v[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("can't remove %v from a scalar value", token)
+	})
+}
+
+// jsonEqual tells whether a and b are the same JSON value, numbers being
+// compared by value.
+func jsonEqual(a interface{}, b interface{}) bool {
+	switch v := a.(type) {
+	case json.Number:
+		w, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, okx := new(big.Rat).SetString(string(v))
+		y, oky := new(big.Rat).SetString(string(w))
+		return okx && oky && x.Cmp(y) == 0
+	case map[string]interface{}:
+		w, ok := b.(map[string]interface{})
+		if !ok || len(v) != len(w) {
+			return false
+		}
+		for key, value := range v {
+			other, ok := w[key]
+			if !ok || !jsonEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		w, ok := b.([]interface{})
+		if !ok || len(v) != len(w) {
+			return false
+		}
+		for i := range v {
+			if !jsonEqual(v[i], w[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func deepCopy(value interface{}) (interface{}, error) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var copied interface{}
+	err = UnmarshalJson(b, &copied)
+	return copied, err
+}
+
+// ApplyJsonPatch applies the operations of a JSON Patch to a copy of doc.
+func ApplyJsonPatch(doc interface{}, ops []patchop) (interface{}, error) {
+	doc, err := deepCopy(doc)
+	if err != nil {
+		return nil, err
+	}
+	for n, op := range ops {
+		tokens, err := pointerTokens(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			value, err = op.value()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d, %v %v: %v", n, op.Op, op.Path, err)
+		}
+		switch op.Op {
+		case "add":
+			doc, err = patchAdd(doc, tokens, value)
+		case "remove":
+			doc, err = patchRemove(doc, tokens)
+		case "replace":
+			_, err = pointerGet(doc, tokens)
+			if err == nil && len(tokens) > 0 {
+				doc, err = patchRemove(doc, tokens)
+			}
+			if err == nil {
+				doc, err = patchAdd(doc, tokens, value)
+			}
+		case "move", "copy":
+			var from []string
+			from, err = pointerTokens(op.From)
+			if err == nil {
+				value, err = pointerGet(doc, from)
+			}
+			if err == nil && op.Op == "move" {
+				if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+					err = fmt.Errorf("can't move %v into itself", op.From)
+				} else {
+					doc, err = patchRemove(doc, from)
+				}
+			}
+			if err == nil && op.Op == "copy" {
+				value, err = deepCopy(value)
+			}
+			if err == nil {
+				doc, err = patchAdd(doc, tokens, value)
+			}
+		case "test":
+			var current interface{}
+			current, err = pointerGet(doc, tokens)
+			if err == nil && !jsonEqual(current, value) {
+				err = fmt.Errorf("test failed, value at %v differs", op.Path)
+			}
+		default:
+			err = fmt.Errorf("unknown operation %v", op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d, %v %v: %v", n, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+// ApplyMergePatch applies a JSON Merge Patch to a copy of doc.
+func ApplyMergePatch(doc interface{}, patch interface{}) (interface{}, error) {
+	doc, err := deepCopy(doc)
+	if err != nil {
+		return nil, err
+	}
+	return mergePatch(doc, patch), nil
+}
+
+func mergePatch(doc interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	docObject, ok := doc.(map[string]interface{})
+	if !ok {
+		docObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(docObject, key)
+		} else {
+			docObject[key] = mergePatch(docObject[key], value)
+		}
+	}
+	return docObject
+}
+
+// ApplyPatch applies a JSON Patch or a JSON Merge Patch, depending on the
+// shape of patch, to a copy of doc.
+func ApplyPatch(doc interface{}, patch []byte) (interface{}, error) {
+	var document interface{}
+	err := UnmarshalJson(patch, &document)
+	if err != nil {
+		HandleErr(err, "Couldn't read the patch as JSON")
+		return nil, err
+	}
+	switch document.(type) {
+	case []interface{}:
+		var ops []patchop
+		err = UnmarshalJson(patch, &ops)
+		if err != nil {
+			HandleErr(err, "Couldn't read the JSON Patch operations")
+			return nil, err
+		}
+		return ApplyJsonPatch(doc, ops)
+	case map[string]interface{}:
+		return ApplyMergePatch(doc, document)
+	}
+	return nil, errors.New("a patch must be a JSON array or object")
+}
+
+// lineDiff returns the lines of a and b prefixed with "- ", "+ " or "  "
+// along their longest common subsequence.
+func lineDiff(a []string, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		} else if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			lines = append(lines, "- "+a[i])
+			i++
+		} else {
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return lines
+}
+
+// ReadInputFile reads the file at path, or stdin when path is "-".
+func ReadInputFile(path string) ([]byte, error) {
+	var reader io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't open file %v", path))
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't read %v", path))
+	}
+	return b, err
+}
+
+// StorePatch applies patch to the content file at path in a single commit.
+// With dryRun, the decrypted document is printed before and after the patch
+// as a diff and nothing is written.
+func StorePatch(path string, patch []byte, dryRun bool, keys *Keyring) error {
+	jsonDocument, err := GetJsonContent(path, keys)
+	if err != nil {
+		return err
+	}
+	patched, err := ApplyPatch(jsonDocument, patch)
+	if err != nil {
+		HandleErr(err, "Couldn't apply the patch")
+		return err
+	}
+	if _, ok := patched.(map[string]interface{}); !ok {
+		return errors.New("the patched document must stay a JSON object")
+	}
+	if dryRun {
+		before, err := json.MarshalIndent(jsonDocument, "", "  ")
+		if err != nil {
+			return err
+		}
+		after, err := json.MarshalIndent(patched, "", "  ")
+		if err != nil {
+			return err
+		}
+		for _, line := range lineDiff(strings.Split(string(before), "\n"), strings.Split(string(after), "\n")) {
+			fmt.Println(line)
+		}
+		return nil
+	}
+	nb, err := json.Marshal(patched)
+	if err != nil {
+		HandleErr(err, "Couldn't marshal JSON content")
+		return err
+	}
+	err = WriteRawJsonContent(path, nb, keys)
+	if err != nil {
+		return err
+	}
+	return StoreUpdateRemote(path, "patch", "", keys)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func decodeJson(t *testing.T, s string) interface{} {
+	var value interface{}
+	err := UnmarshalJson([]byte(s), &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestApplyPatch(t *testing.T) {
+	cases := []struct {
+		doc      string
+		patch    string
+		expected string
+	}{
+		{`{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`},
+		{`{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": "baz"}]`, `{"foo": ["bar", "baz"]}`},
+		{`{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`},
+		{`{"a": {"b": 1}, "c": 2}`, `[{"op": "replace", "path": "/c", "value": 3}, {"op": "move", "from": "/a/b", "path": "/d"}]`, `{"a": {}, "c": 3, "d": 1}`},
+		{`{"a": {"b": 1}}`, `[{"op": "copy", "from": "/a", "path": "/a~1c"}, {"op": "test", "path": "/a~1c/b", "value": 1}]`, `{"a": {"b": 1}, "a/c": {"b": 1}}`},
+		{`{"a": 1, "b": [10]}`, `[{"op": "test", "path": "/a", "value": 1.0}, {"op": "test", "path": "/b", "value": [1e1]}, {"op": "replace", "path": "/a", "value": null}]`, `{"a": null, "b": [10]}`},
+		{`{"title": "Goodbye!", "author": {"givenName": "John", "familyName": "Doe"}, "tags": ["example", "sample"]}`,
+			`{"title": "Hello!", "author": {"familyName": null}, "tags": ["example"], "phoneNumber": "+01-234"}`,
+			`{"title": "Hello!", "author": {"givenName": "John"}, "tags": ["example"], "phoneNumber": "+01-234"}`},
+	}
+	for _, c := range cases {
+		doc := decodeJson(t, c.doc)
+		patched, err := ApplyPatch(doc, []byte(c.patch))
+		if err != nil {
+			t.Fatal(c.patch, err)
+		}
+		if !reflect.DeepEqual(patched, decodeJson(t, c.expected)) {
+			t.Error("Expecting", c.expected, "after", c.patch, "got", patched)
+		}
+		if !reflect.DeepEqual(doc, decodeJson(t, c.doc)) {
+			t.Error("Expecting the original document to be left untouched by", c.patch)
+		}
+	}
+}
+
+func TestApplyPatchFailure(t *testing.T) {
+	doc := decodeJson(t, `{"a": 1, "b": [1]}`)
+	for _, patch := range []string{
+		`[{"op": "add", "path": "/x", "value": 1}, {"op": "test", "path": "/a", "value": 2}]`,
+		`[{"op": "remove", "path": "/missing"}]`,
+		`[{"op": "add", "path": "/b/5", "value": 1}]`,
+		`[{"op": "move", "from": "/b", "path": "/b/0"}]`,
+		`[{"op": "unknown", "path": "/a"}]`,
+		`[{"op": "add", "path": "/x"}]`,
+		`[{"op": "replace", "path": "/a"}]`,
+		`[{"op": "test", "path": "/a"}]`,
+		`[{"op": "test", "path": "/a", "value": "1"}]`,
+		`"not a patch"`,
+	} {
+		_, err := ApplyPatch(doc, []byte(patch))
+		if err == nil {
+			t.Error("Expecting an error applying", patch)
+		}
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	lines := lineDiff([]string{"a", "b", "c"}, []string{"a", "c", "d"})
+	expected := []string{"  a", "- b", "  c", "+ d"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Error("Expecting", expected, "got", lines)
+	}
+}
@@ -11,8 +11,8 @@ const (
 	ROOT_FOLDER_NAME = "vstore"
 )
 
-func FindObjectPath(objectName string, selector func(string, fuzzy.Matches) (string, error)) (string, error) {
-	matches, err := GetFuzzyPath(objectName)
+func FindObjectPath(objectName string, selector func(string, fuzzy.Matches) (string, error), keys *Keyring) (string, error) {
+	matches, err := GetFuzzyPath(objectName, keys)
 	if err != nil {
 		return "", err
 	}
@@ -31,16 +31,8 @@ func FindObjectPath(objectName string, selector func(string, fuzzy.Matches) (str
 	}
 }
 
-func GetFuzzyPath(objectName string) (fuzzy.Matches, error) {
-	storepath, err := GetStorePath()
-	if err != nil {
-		return nil, err
-	}
-	exists, _ := PathExists(storepath)
-	if !exists {
-		return fuzzy.Matches{}, nil
-	}
-	files, err := FilePathWalkDir(storepath)
+func GetFuzzyPath(objectName string, keys *Keyring) (fuzzy.Matches, error) {
+	files, err := ListObjects(keys)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +43,7 @@ func GetFuzzyPath(objectName string) (fuzzy.Matches, error) {
 func FilePathWalkDir(root string) ([]string, error) {
 	var files []string
 	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
+		if !info.IsDir() && info.Name() != RECIPIENTS_FILE {
 			storepath, err := GetStorePath()
 			if err != nil {
 				return err
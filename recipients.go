@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// Objects in a directory holding a recipients file, or below it, are not
+// sealed under the store key but to the users listed in the nearest
+// recipients file, in the style of age: a random file key encrypts the
+// content and is wrapped for every recipient with an X25519 key agreement.
+// The public keys of the team are registered in the users file. With
+// encrypted names, the recipients of each directory are kept in the index
+// instead, so the recipients files don't reveal directory names.
+//
+// Neither file is authenticated: anyone able to push can add a key to them
+// and have the next writes encrypted to it. The keys this machine encrypts
+// to are therefore pinned in the known recipients file, outside the
+// repository: a recipient not known yet must be approved, and a known name
+// whose key changed is refused.
+const (
+	RECIPIENTS_FILE       = ".recipients"
+	USERS_FILE            = "users"
+	KNOWN_RECIPIENTS_FILE = "known-recipients.json"
+	X25519_KEY_BYTES      = 32
+	FILE_KEY_BYTES        = PW_KEY_BYTES
+	// file key sealed with ChaCha20-Poly1305
+	WRAPPED_KEY_BYTES = FILE_KEY_BYTES + 16
+)
+
+var ErrNotRecipient = errors.New("not a recipient: the object isn't encrypted to this user's identity")
+
+// RecipientApprover tells whether to encrypt the objects of dir to a
+// recipient not known yet, it asks on stdin.
+var RecipientApprover func(dir string, r recipient) bool = StdinRecipientApprover
+
+type RecipientStanza struct {
+	Ephemeral  [X25519_KEY_BYTES]byte
+	WrappedKey [WRAPPED_KEY_BYTES]byte
+}
+
+type recipient struct {
+	Name      string
+	PublicKey [X25519_KEY_BYTES]byte
+}
+
+func GenerateIdentity() (string, error) {
+	var identity [X25519_KEY_BYTES]byte
+	_, err := io.ReadFull(rand.Reader, identity[:])
+	if err != nil {
+		HandleErr(err, "Couldn't get enough entropy")
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(identity[:]), nil
+}
+
+func decodeX25519Key(encoded string) ([X25519_KEY_BYTES]byte, error) {
+	var key [X25519_KEY_BYTES]byte
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return key, err
+	}
+	if len(b) != X25519_KEY_BYTES {
+		return key, errors.New("malformed X25519 key")
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+// IdentityPublicKey returns the public key of the encoded identity.
+func IdentityPublicKey(identity string) (string, error) {
+	private, err := decodeX25519Key(identity)
+	if err != nil {
+		return "", err
+	}
+	public, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(public), nil
+}
+
+func stanzaWrapKey(shared []byte, ephemeral []byte, public []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), public...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte("vstore x25519")), key)
+	return key, err
+}
+
+// WrapFileKey wraps fileKey for the recipient public key.
+func WrapFileKey(fileKey *[FILE_KEY_BYTES]byte, public [X25519_KEY_BYTES]byte) (RecipientStanza, error) {
+	var stanza RecipientStanza
+	var ephemeral [X25519_KEY_BYTES]byte
+	_, err := io.ReadFull(rand.Reader, ephemeral[:])
+	if err != nil {
+		HandleErr(err, "Couldn't get enough entropy")
+		return stanza, err
+	}
+	ephemeralPublic, err := curve25519.X25519(ephemeral[:], curve25519.Basepoint)
+	if err != nil {
+		return stanza, err
+	}
+	shared, err := curve25519.X25519(ephemeral[:], public[:])
+	if err != nil {
+		return stanza, err
+	}
+	wrapKey, err := stanzaWrapKey(shared, ephemeralPublic, public[:])
+	if err != nil {
+		return stanza, err
+	}
+	aead, err := chacha20poly1305.New(wrapKey)
+	if err != nil {
+		return stanza, err
+	}
+	// every wrap key is used once, a zero nonce is fine
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	copy(stanza.Ephemeral[:], ephemeralPublic)
+	copy(stanza.WrappedKey[:], aead.Seal(nil, nonce, fileKey[:], nil))
+	return stanza, nil
+}
+
+// UnwrapFileKey looks for a stanza wrapped for identity and returns the file
+// key it holds.
+func UnwrapFileKey(stanzas []RecipientStanza, identity string) ([FILE_KEY_BYTES]byte, error) {
+	var fileKey [FILE_KEY_BYTES]byte
+	if identity == "" {
+		return fileKey, fmt.Errorf("%w, there is no identity in settings, run vstore identity", ErrNotRecipient)
+	}
+	private, err := decodeX25519Key(identity)
+	if err != nil {
+		return fileKey, err
+	}
+	public, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	if err != nil {
+		return fileKey, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for _, stanza := range stanzas {
+		shared, err := curve25519.X25519(private[:], stanza.Ephemeral[:])
+		if err != nil {
+			continue
+		}
+		wrapKey, err := stanzaWrapKey(shared, stanza.Ephemeral[:], public)
+		if err != nil {
+			return fileKey, err
+		}
+		aead, err := chacha20poly1305.New(wrapKey)
+		if err != nil {
+			return fileKey, err
+		}
+		key, err := aead.Open(nil, nonce, stanza.WrappedKey[:], nil)
+		if err == nil {
+			copy(fileKey[:], key)
+			return fileKey, nil
+		}
+	}
+	return fileKey, ErrNotRecipient
+}
+
+// SealForRecipients encrypts plaintext with a fresh file key wrapped for
+// every recipient.
+func SealForRecipients(plaintext []byte, recipients []recipient, location string, padding byte) ([]byte, error) {
+	var fileKey [FILE_KEY_BYTES]byte
+	_, err := io.ReadFull(rand.Reader, fileKey[:])
+	if err != nil {
+		HandleErr(err, "Couldn't get enough entropy")
+		return nil, err
+	}
+	kdf := KdfParams{Id: KDF_X25519_RECIPIENTS}
+	for _, r := range recipients {
+		stanza, err := WrapFileKey(&fileKey, r.PublicKey)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't wrap the file key for %v", r.Name))
+			return nil, err
+		}
+		kdf.Stanzas = append(kdf.Stanzas, stanza)
+	}
+	source := func(KdfParams) ([PW_KEY_BYTES]byte, error) {
+		return fileKey, nil
+	}
+	return SealEnvelope(plaintext, kdf, source, location, padding)
+}
+
+func GetUsersPath() (string, error) {
+	path, err := GetMetaPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, USERS_FILE), nil
+}
+
+// GetUsers returns the public keys registered in the users file by name.
+func GetUsers() (map[string]string, error) {
+	users := map[string]string{}
+	path, err := GetUsersPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return users, nil
+		}
+		HandleErr(err, fmt.Sprintf("Couldn't read users file at path %v", path))
+		return nil, err
+	}
+	err = json.Unmarshal(b, &users)
+	if err != nil {
+		HandleErr(err, "Couldn't read users file as JSON object")
+	}
+	return users, err
+}
+
+// RegisterUser records the public key of name in the users file and commits
+// it.
+func RegisterUser(name string, publicKey string, keys *Keyring) error {
+	users, err := GetUsers()
+	if err != nil {
+		return err
+	}
+	if users[name] == publicKey {
+		return nil
+	}
+	if _, ok := users[name]; ok {
+		return fmt.Errorf("user %v is already registered with another key", name)
+	}
+	users[name] = publicKey
+	b, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	path, err := GetUsersPath()
+	if err != nil {
+		return err
+	}
+	err = CreateMetaDir()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, b, 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write users file at path %v", path))
+		return err
+	}
+	return StoreCommit([]string{path}, fmt.Sprintf("Register user %s", name), keys)
+}
+
+// Identity prints the public key of the user, generating an identity first
+// when the settings have none. With a name, the public key is registered in
+// the users file under that name so others can share with the user.
+func Identity(password string, settings usersettings, name string, keys *Keyring) error {
+	save := false
+	if settings.Identity == "" {
+		identity, err := GenerateIdentity()
+		if err != nil {
+			return err
+		}
+		settings.Identity = identity
+		keys.Identity = identity
+		save = true
+	}
+	if name != "" && name != settings.User {
+		settings.User = name
+		save = true
+	}
+	if save {
+		err := CreateEncodedSettingsFile(password, settings)
+		if err != nil {
+			HandleErr(err, "Couldn't save the identity")
+			return err
+		}
+	}
+	publicKey, err := IdentityPublicKey(settings.Identity)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		err = RegisterUser(name, publicKey, keys)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println(publicKey)
+	return nil
+}
+
+func getRecipientsPath(dir string) (string, error) {
+	storepath, err := GetStorePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storepath, filepath.FromSlash(dir), RECIPIENTS_FILE), nil
+}
+
+// readRecipientsFile returns the recipients of dir, from its recipients file
+// or from the index when names are encrypted. It returns nil when dir has no
+// recipients.
+func readRecipientsFile(dir string, keys *Keyring) ([]recipient, error) {
+	index, err := keys.Index()
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		listed, ok := index.Recipients[dir]
+		if !ok {
+			return nil, nil
+		}
+		return parseRecipients([]byte(listed), "the recipients of /"+dir)
+	}
+	path, err := getRecipientsPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		HandleErr(err, fmt.Sprintf("Couldn't read recipients file at path %v", path))
+		return nil, err
+	}
+	return parseRecipients(b, path)
+}
+
+// parseRecipients parses a list of recipients, one user name and public key
+// per line, read from source.
+func parseRecipients(b []byte, source string) ([]recipient, error) {
+	recipients := []recipient{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed line in %v: %v", source, line)
+		}
+		publicKey, err := decodeX25519Key(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed public key for %v in %v", fields[0], source)
+		}
+		recipients = append(recipients, recipient{Name: fields[0], PublicKey: publicKey})
+	}
+	return recipients, nil
+}
+
+// formatRecipients returns the list of recipients as parsed by
+// parseRecipients, sorted by name.
+func formatRecipients(recipients []recipient) []byte {
+	sort.Slice(recipients, func(i, j int) bool { return recipients[i].Name < recipients[j].Name })
+	var buf bytes.Buffer
+	for _, r := range recipients {
+		fmt.Fprintf(&buf, "%s %s\n", r.Name, base64.StdEncoding.EncodeToString(r.PublicKey[:]))
+	}
+	return buf.Bytes()
+}
+
+// writeRecipientsFile sets the recipients of dir, none removes them, and
+// returns the path of the file to commit: the recipients file, or the index
+// when names are encrypted.
+func writeRecipientsFile(dir string, recipients []recipient, keys *Keyring) (string, error) {
+	index, err := keys.Index()
+	if err != nil {
+		return "", err
+	}
+	if index != nil {
+		if len(recipients) == 0 {
+			delete(index.Recipients, dir)
+		} else {
+			if index.Recipients == nil {
+				index.Recipients = map[string]string{}
+			}
+			index.Recipients[dir] = string(formatRecipients(recipients))
+		}
+		index.dirty = true
+		return GetIndexPath()
+	}
+	path, err := getRecipientsPath(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(recipients) == 0 {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			HandleErr(err, fmt.Sprintf("Couldn't remove recipients file at path %v", path))
+			return "", err
+		}
+		return path, nil
+	}
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't create dirs %v", path))
+		return "", err
+	}
+	err = ioutil.WriteFile(path, formatRecipients(recipients), 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write recipients file at path %v", path))
+	}
+	return path, err
+}
+
+// recipientsDir returns the directory of the recipients applying to the
+// object at location, or false when the object is sealed under the store key.
+func recipientsDir(location string, keys *Keyring) (string, bool, error) {
+	index, err := keys.Index()
+	if err != nil {
+		return "", false, err
+	}
+	dir := path.Dir(location)
+	for {
+		if dir == "." {
+			dir = ""
+		}
+		if index != nil {
+			if _, ok := index.Recipients[dir]; ok {
+				return dir, true, nil
+			}
+			if dir == "" {
+				return "", false, nil
+			}
+			dir = path.Dir(dir)
+			continue
+		}
+		recipientsPath, err := getRecipientsPath(dir)
+		if err != nil {
+			return "", false, err
+		}
+		exists, err := PathExists(recipientsPath)
+		if err != nil {
+			return "", false, err
+		}
+		if exists {
+			return dir, true, nil
+		}
+		if dir == "" {
+			return "", false, nil
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// recipientsDirAt is recipientsDir in the tree of commit, whose index is
+// given when names are encrypted.
+func recipientsDirAt(commit *object.Commit, location string, index *objectindex) (string, bool) {
+	dir := path.Dir(location)
+	for {
+		if dir == "." {
+			dir = ""
+		}
+		if index != nil {
+			if _, ok := index.Recipients[dir]; ok {
+				return dir, true
+			}
+		} else if _, ok := fileHash(commit, path.Join(STORE_FOLDER_NAME, dir, RECIPIENTS_FILE)); ok {
+			return dir, true
+		}
+		if dir == "" {
+			return "", false
+		}
+		dir = path.Dir(dir)
+	}
+}
+
+// RecipientsFor returns the recipients of the object at location, none when
+// it is sealed under the store key. Recipients not known yet are approved
+// and pinned first.
+func RecipientsFor(location string, keys *Keyring) ([]recipient, error) {
+	dir, ok, err := recipientsDir(location, keys)
+	if err != nil || !ok {
+		return nil, err
+	}
+	recipients, err := readRecipientsFile(dir, keys)
+	if err != nil {
+		return nil, err
+	}
+	known, err := GetKnownRecipients()
+	if err != nil {
+		return nil, err
+	}
+	added, err := checkKnownRecipients(known, dir, recipients, RecipientApprover)
+	if err != nil || !added {
+		return recipients, err
+	}
+	return recipients, saveKnownRecipients(known)
+}
+
+func GetKnownRecipientsPath() (string, error) {
+	path, err := GetRootPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, KNOWN_RECIPIENTS_FILE), nil
+}
+
+// GetKnownRecipients returns the public keys pinned on this machine by name.
+func GetKnownRecipients() (map[string]string, error) {
+	known := map[string]string{}
+	path, err := GetKnownRecipientsPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return known, nil
+		}
+		HandleErr(err, fmt.Sprintf("Couldn't read known recipients file at path %v", path))
+		return nil, err
+	}
+	err = json.Unmarshal(b, &known)
+	if err != nil {
+		HandleErr(err, "Couldn't read known recipients file as JSON object")
+	}
+	return known, err
+}
+
+func saveKnownRecipients(known map[string]string) error {
+	b, err := json.MarshalIndent(known, "", "  ")
+	if err != nil {
+		return err
+	}
+	path, err := GetKnownRecipientsPath()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, b, 0600)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write known recipients file at path %v", path))
+	}
+	return err
+}
+
+// PinRecipients adds the recipients of dir chosen by the user to the known
+// recipients. A known name with another key is still refused.
+func PinRecipients(dir string, recipients []recipient) error {
+	known, err := GetKnownRecipients()
+	if err != nil {
+		return err
+	}
+	added, err := checkKnownRecipients(known, dir, recipients, func(string, recipient) bool { return true })
+	if err != nil || !added {
+		return err
+	}
+	return saveKnownRecipients(known)
+}
+
+// checkKnownRecipients refuses the recipients of dir whose name is known with
+// another key and adds the ones approved to known. It tells whether known
+// changed.
+func checkKnownRecipients(known map[string]string, dir string, recipients []recipient, approve func(dir string, r recipient) bool) (bool, error) {
+	added := false
+	for _, r := range recipients {
+		encoded := base64.StdEncoding.EncodeToString(r.PublicKey[:])
+		pinned, ok := known[r.Name]
+		if ok && pinned == encoded {
+			continue
+		}
+		if ok {
+			return added, fmt.Errorf("the key of recipient %v in /%v changed, refusing to encrypt to it, remove it from %v if the change is expected", r.Name, dir, KNOWN_RECIPIENTS_FILE)
+		}
+		if !approve(dir, r) {
+			return added, fmt.Errorf("recipient %v in /%v not approved", r.Name, dir)
+		}
+		known[r.Name] = encoded
+		added = true
+	}
+	return added, nil
+}
+
+// StdinRecipientApprover shows the key of a new recipient and asks whether
+// to encrypt to it.
+func StdinRecipientApprover(dir string, r recipient) bool {
+	fmt.Printf("%s is a new recipient of /%s with key %s\n", r.Name, dir, base64.StdEncoding.EncodeToString(r.PublicKey[:]))
+	fmt.Print("Check the key with them, encrypt to it? [y/N] ")
+	var answer string
+	fmt.Scanln(&answer)
+	return answer == "y" || answer == "yes"
+}
+
+// resealDir seals again every object whose recipients are declared in dir,
+// after its recipients file changed, and commits everything as one commit.
+func resealDir(dir string, contents map[string][]byte, recipientsPath string, message string, keys *Keyring) error {
+	storepath, err := GetStorePath()
+	if err != nil {
+		return err
+	}
+	paths := []string{recipientsPath}
+	for location, rawjson := range contents {
+		path := filepath.Join(storepath, filepath.FromSlash(location))
+		err = WriteRawJsonContent(path, rawjson, keys)
+		if err != nil {
+			return err
+		}
+		physical, err := PhysicalPath(path, keys, false)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, physical)
+	}
+	fmt.Printf("Re-encrypted %d files\n", len(contents))
+	return StoreCommit(paths, message, keys)
+}
+
+// readDirContents decrypts every object governed by the recipients file of
+// dir, or by no recipients file when dir has none.
+func readDirContents(dir string, keys *Keyring) (map[string][]byte, error) {
+	files, err := ListObjects(keys)
+	if err != nil {
+		return nil, err
+	}
+	storepath, err := GetStorePath()
+	if err != nil {
+		return nil, err
+	}
+	contents := map[string][]byte{}
+	for _, file := range files {
+		location := filepath.ToSlash(file)
+		if dir != "" && !strings.HasPrefix(location, dir+"/") {
+			continue
+		}
+		governing, ok, err := recipientsDir(location, keys)
+		if err != nil {
+			return nil, err
+		}
+		// objects governed by a recipients file deeper than dir don't change
+		if ok && governing != dir && (dir == "" || strings.HasPrefix(governing, dir+"/")) {
+			continue
+		}
+		rawjson, err := GetRawJsonContent(filepath.Join(storepath, file), keys)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't decrypt file %v", file))
+			return nil, err
+		}
+		contents[location] = rawjson
+	}
+	return contents, nil
+}
+
+// Share adds user to the recipients of dir and encrypts the objects of dir to
+// the new recipients list. When dir had no recipients yet, the current user
+// is added as well.
+func Share(dir string, user string, settings usersettings, keys *Keyring) error {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+	users, err := GetUsers()
+	if err != nil {
+		return err
+	}
+	encoded, ok := users[user]
+	if !ok {
+		return fmt.Errorf("unknown user %v, they need to run vstore identity %v first", user, user)
+	}
+	publicKey, err := decodeX25519Key(encoded)
+	if err != nil {
+		return err
+	}
+	// read with the current recipients before changing them
+	contents, err := readDirContents(dir, keys)
+	if err != nil {
+		return err
+	}
+	recipients, err := readRecipientsFile(dir, keys)
+	if err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		if r.Name == user {
+			return fmt.Errorf("%v is already a recipient of %v", user, dir)
+		}
+	}
+	pinned := []recipient{}
+	if recipients == nil {
+		if settings.Identity == "" || settings.User == "" {
+			return errors.New("register your own identity first with vstore identity <name>")
+		}
+		self, err := IdentityPublicKey(settings.Identity)
+		if err != nil {
+			return err
+		}
+		selfKey, err := decodeX25519Key(self)
+		if err != nil {
+			return err
+		}
+		if settings.User != user {
+			self := recipient{Name: settings.User, PublicKey: selfKey}
+			recipients = append(recipients, self)
+			pinned = append(pinned, self)
+		}
+	}
+	shared := recipient{Name: user, PublicKey: publicKey}
+	recipients = append(recipients, shared)
+	// the user chose to share with them, the other recipients are still
+	// checked when writing
+	err = PinRecipients(dir, append(pinned, shared))
+	if err != nil {
+		return err
+	}
+	recipientsPath, err := writeRecipientsFile(dir, recipients, keys)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Share %s with %s", "/"+dir, user)
+	if NamesEncrypted() {
+		message = fmt.Sprintf("Share with %s", user)
+	}
+	return resealDir(dir, contents, recipientsPath, message, keys)
+}
+
+// Unshare removes user from the recipients of dir and encrypts the objects of
+// dir again so they can't be opened with the user's identity. Values the user
+// could read before should still be changed.
+func Unshare(dir string, user string, keys *Keyring) error {
+	dir = strings.Trim(filepath.ToSlash(dir), "/")
+	recipients, err := readRecipientsFile(dir, keys)
+	if err != nil {
+		return err
+	}
+	kept := []recipient{}
+	for _, r := range recipients {
+		if r.Name != user {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == len(recipients) {
+		return fmt.Errorf("%v is not a recipient of %v", user, dir)
+	}
+	contents, err := readDirContents(dir, keys)
+	if err != nil {
+		return err
+	}
+	recipientsPath, err := writeRecipientsFile(dir, kept, keys)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Unshare %s with %s", "/"+dir, user)
+	if NamesEncrypted() {
+		message = fmt.Sprintf("Unshare with %s", user)
+	}
+	return resealDir(dir, contents, recipientsPath, message, keys)
+}
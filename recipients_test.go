@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testRecipient(t *testing.T, name string) (string, recipient) {
+	identity, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := IdentityPublicKey(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := decodeX25519Key(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity, recipient{Name: name, PublicKey: publicKey}
+}
+
+func TestSealForRecipients(t *testing.T) {
+	aliceIdentity, alice := testRecipient(t, "alice")
+	bobIdentity, bob := testRecipient(t, "bob")
+	eveIdentity, _ := testRecipient(t, "eve")
+	sealed, err := SealForRecipients([]byte("shared"), []recipient{alice, bob}, "team/wifi", PADDING_PADME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf, err := EnvelopeKdf(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kdf.Id != KDF_X25519_RECIPIENTS || len(kdf.Stanzas) != 2 {
+		t.Error("Expecting one stanza per recipient, got", kdf.Id, len(kdf.Stanzas))
+	}
+	for _, identity := range []string{aliceIdentity, bobIdentity} {
+		keys := &Keyring{Identity: identity}
+		plaintext, err := keys.openShared(sealed, "team/wifi", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plaintext) != "shared" {
+			t.Error("Expecting every recipient to read the content, got", string(plaintext))
+		}
+	}
+	keys := &Keyring{Identity: eveIdentity}
+	_, err = keys.openShared(sealed, "team/wifi", true)
+	if err != ErrNotRecipient {
+		t.Error("Expecting a not recipient error for another identity, got", err)
+	}
+	// anyone can seal for the recipients, not where they don't apply
+	keys = &Keyring{Identity: aliceIdentity}
+	_, err = keys.openShared(sealed, "team/wifi", false)
+	if err != ErrWrongSealing {
+		t.Error("Expecting content sealed for recipients to be refused outside shared directories, got", err)
+	}
+	storeSealed, err := testKeyring(t).Seal([]byte("private"), "team/wifi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = keys.openShared(storeSealed, "team/wifi", true)
+	if err != ErrWrongSealing {
+		t.Error("Expecting content sealed under the store key to be refused in shared directories, got", err)
+	}
+}
+
+func TestRecipientsDirAt(t *testing.T) {
+	repo, commit := testRepo(t)
+	c, err := repo.CommitObject(commit("share", map[string]string{"store/team/" + RECIPIENTS_FILE: "", "store/team/wifi": ""}, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, ok := recipientsDirAt(c, "team/inner/wifi", nil); !ok || dir != "team" {
+		t.Error("Expecting team to be the recipients directory, got", dir, ok)
+	}
+	if _, ok := recipientsDirAt(c, "other/wifi", nil); ok {
+		t.Error("Expecting no recipients outside team")
+	}
+}
+
+func TestCheckKnownRecipients(t *testing.T) {
+	_, alice := testRecipient(t, "alice")
+	_, bob := testRecipient(t, "bob")
+	_, mallory := testRecipient(t, "bob")
+	asked := []string{}
+	approve := func(answer bool) func(dir string, r recipient) bool {
+		return func(dir string, r recipient) bool {
+			asked = append(asked, r.Name)
+			return answer
+		}
+	}
+	known := map[string]string{}
+	added, err := checkKnownRecipients(known, "team", []recipient{alice, bob}, approve(true))
+	if err != nil || !added || len(known) != 2 {
+		t.Fatal("Expecting approved recipients to be pinned, got", added, err, known)
+	}
+	asked = nil
+	added, err = checkKnownRecipients(known, "team", []recipient{alice, bob}, approve(false))
+	if err != nil || added || len(asked) != 0 {
+		t.Error("Expecting known recipients to pass without asking, got", added, err, asked)
+	}
+	_, err = checkKnownRecipients(known, "team", []recipient{alice, mallory}, approve(true))
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Error("Expecting a known name with another key to be refused, got", err)
+	}
+	_, eve := testRecipient(t, "eve")
+	_, err = checkKnownRecipients(known, "team", []recipient{alice, eve}, approve(false))
+	if err == nil || known["eve"] != "" {
+		t.Error("Expecting a recipient not approved to be refused, got", err, known)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The recovery kit splits the master key in shares handed to several people,
+// any threshold of them can rebuild it after whoever held it is gone.
+const (
+	RECOVERY_DEFAULT_SHARES    = 5
+	RECOVERY_DEFAULT_THRESHOLD = 3
+)
+
+// RecoverySplit prints the shares of the master key, one per line.
+func RecoverySplit(settings usersettings, shares int, threshold int) error {
+	if settings.MasterKey == "" {
+		return errors.New("no master key in settings")
+	}
+	split, err := ShamirSplit([]byte(settings.MasterKey), shares, threshold)
+	if err != nil {
+		HandleErr(err, "Couldn't split the master key")
+		return err
+	}
+	fmt.Printf("Any %d of these %d shares rebuild the master key, hand each one to a different person:\n", threshold, shares)
+	for _, s := range split {
+		fmt.Println(EncodeShare(s))
+	}
+	return nil
+}
+
+// RecoveryCombine reads shares from stdin until there are enough of them,
+// rebuilds the master key, checks it unwraps the local store key and writes
+// a fresh settings file protected by password. Existing settings are kept,
+// only their master key is replaced.
+func RecoveryCombine(password string) error {
+	shares := []share{}
+	scanner := bufio.NewScanner(os.Stdin)
+	for len(shares) == 0 || len(shares) < shares[0].Threshold {
+		fmt.Printf("share %d: ", len(shares)+1)
+		if !scanner.Scan() {
+			return errors.New("not enough shares")
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		s, err := DecodeShare(line)
+		if err == nil && len(shares) > 0 && s.Id != shares[0].Id {
+			err = errors.New("the share comes from another split")
+		}
+		if err != nil {
+			HandleErr(err, "Couldn't read the share, enter it again")
+			continue
+		}
+		shares = append(shares, s)
+	}
+	masterKey, err := ShamirCombine(shares)
+	if err != nil {
+		HandleErr(err, "Couldn't rebuild the master key")
+		return err
+	}
+	// check the key opens the store before replacing anything
+	_, err = ReadStoreKey(string(masterKey))
+	if os.IsNotExist(err) {
+		fmt.Println("No local store to check the master key against yet")
+	} else if err != nil {
+		return err
+	}
+	path, err := GetSettingsFilePath()
+	if err != nil {
+		return err
+	}
+	settings := usersettings{}
+	exists, _ := PathExists(path)
+	if exists {
+		settings, err = GetSettings(password)
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Print("remote: ")
+		fmt.Scanln(&settings.Remote)
+	}
+	settings.MasterKey = string(masterKey)
+	err = CreateEncodedSettingsFile(password, settings)
+	if err != nil {
+		HandleErr(err, "Couldn't write the settings file")
+		return err
+	}
+	fmt.Println("Master key recovered")
+	return nil
+}
+
+// Recovery runs the recovery subcommands, split and combine.
+func Recovery(args []string, password string) error {
+	if len(args) == 0 {
+		return errors.New("missing recovery subcommand, split or combine")
+	}
+	switch args[0] {
+	case "split":
+		flags := flag.NewFlagSet("recovery split", flag.ContinueOnError)
+		shares := flags.Int("shares", RECOVERY_DEFAULT_SHARES, "number of shares")
+		threshold := flags.Int("threshold", RECOVERY_DEFAULT_THRESHOLD, "number of shares needed to recover")
+		err := flags.Parse(args[1:])
+		if err != nil {
+			return err
+		}
+		settings, err := GetSettings(password)
+		if err != nil {
+			return err
+		}
+		return RecoverySplit(settings, *shares, *threshold)
+	case "combine":
+		if len(args) != 1 {
+			return errors.New("recovery combine takes no arguments")
+		}
+		return RecoveryCombine(password)
+	}
+	return fmt.Errorf("unknown recovery subcommand %v", args[0])
+}
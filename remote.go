@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+)
+
+// A store created with init --local has no remote: nothing is pulled nor
+// pushed until one is added. The store is pulled from origin only, and pushed
+// to origin and to every mirror, on each commit or on sync only depending on
+// their push policy.
+const (
+	PUSH_ALWAYS    = "always"
+	PUSH_SYNC      = "sync"
+	SYNC_ONLY_FLAG = "--sync-only"
+)
+
+// HasRemote tells whether the store repository has a remote to sync with.
+func HasRemote(repo *git.Repository) bool {
+	_, err := repo.Remote(REMOTE_NAME)
+	return err == nil
+}
+
+// InitLocalStore creates an empty store repository with no remote and commits
+// the store config in it.
+func InitLocalStore(keys *Keyring) error {
+	path, err := GetRepoPath()
+	if err != nil {
+		return err
+	}
+	exists, err := PathExists(path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("a store already exists at %v, remove it with vstore reset first", path)
+	}
+	err = os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		HandleErr(err, "Couldn't create the repo directory")
+		return err
+	}
+	_, err = git.PlainInit(path, false)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't create a repository at path %v", path))
+		return err
+	}
+	configPath, err := WriteStoreConfig(storeconfig{})
+	if err != nil {
+		return err
+	}
+	err = StoreCommit([]string{configPath}, "Create store", keys)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created a local store at %v\n", path)
+	return nil
+}
+
+// AddRemote attaches the remote at url under name and pushes the store
+// history to it. origin can only be added to a local store, it becomes the
+// remote the store is pulled from; other remotes are mirrors only pushed to.
+func AddRemote(name string, url string, push string, password string, settings usersettings, keys *Keyring) error {
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	if name == REMOTE_NAME && HasRemote(repo) {
+		return errors.New("the store already has a remote")
+	}
+	for _, remote := range settings.RemoteList() {
+		if remote.Name == name {
+			return fmt.Errorf("remote %v already exists", name)
+		}
+	}
+	remote := remotesettings{Name: name, URL: url, Push: push}
+	err = ensureGitRemote(repo, remote)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't add remote %v", url))
+		return err
+	}
+	if name == REMOTE_NAME {
+		settings.Remote = url
+	}
+	if name != REMOTE_NAME || push != PUSH_ALWAYS {
+		settings.Remotes = append(settings.Remotes, remote)
+	}
+	err = CreateEncodedSettingsFile(password, settings)
+	if err != nil {
+		return err
+	}
+	if Offline {
+		fmt.Println("Remote added, run vstore sync to push")
+		return nil
+	}
+	err = pushRemote(repo, remote, keys)
+	if err != nil {
+		HandleErr(err, "Couldn't push the store, run vstore sync later")
+		return err
+	}
+	fmt.Printf("Pushed the store to %v\n", url)
+	return nil
+}
+
+// RemoveRemote stops pushing to the mirror name. origin can't be removed.
+func RemoveRemote(name string, password string, settings usersettings) error {
+	if name == REMOTE_NAME {
+		return errors.New("origin is the remote the store is pulled from, it can't be removed")
+	}
+	kept := []remotesettings{}
+	for _, remote := range settings.Remotes {
+		if remote.Name != name {
+			kept = append(kept, remote)
+		}
+	}
+	if len(kept) == len(settings.Remotes) {
+		return fmt.Errorf("no remote named %v", name)
+	}
+	settings.Remotes = kept
+	err := CreateEncodedSettingsFile(password, settings)
+	if err != nil {
+		return err
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	err = repo.DeleteRemote(name)
+	if err != nil && err != git.ErrRemoteNotFound {
+		HandleErr(err, fmt.Sprintf("Couldn't remove remote %v from the repository", name))
+		return err
+	}
+	fmt.Printf("Removed remote %v\n", name)
+	return nil
+}
+
+// ListRemotes prints the remotes of the store with their push policy.
+func ListRemotes(settings usersettings) {
+	remotes := settings.RemoteList()
+	if len(remotes) == 0 {
+		fmt.Println("Local store, add a remote with vstore remote add <url>")
+	}
+	for _, remote := range remotes {
+		fmt.Printf("%s\t%s\t%s\n", remote.Name, remote.URL, remote.Push)
+	}
+}
+
+// ensureGitRemote configures remote in the repository, mirrors are only known
+// to the settings on a fresh clone.
+func ensureGitRemote(repo *git.Repository, remote remotesettings) error {
+	existing, err := repo.Remote(remote.Name)
+	if err == nil {
+		urls := existing.Config().URLs
+		if len(urls) > 0 && urls[0] == remote.URL {
+			return nil
+		}
+		err = repo.DeleteRemote(remote.Name)
+		if err != nil {
+			return err
+		}
+	} else if err != git.ErrRemoteNotFound {
+		return err
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: remote.Name, URLs: []string{remote.URL}})
+	return err
+}
+
+// pushRemote pushes the local branch to remote. Pushes to origin merge the
+// remote changes when rejected, mirrors are expected to never move on their
+// own.
+func pushRemote(repo *git.Repository, remote remotesettings, keys *Keyring) error {
+	if remote.Name == REMOTE_NAME {
+		return PushStore(repo, keys)
+	}
+	err := ensureGitRemote(repo, remote)
+	if err != nil {
+		return err
+	}
+	auth, err := gitAuth(remote.Auth, remote.URL)
+	if err != nil {
+		return err
+	}
+	err = repo.Push(&git.PushOptions{RemoteName: remote.Name, Auth: auth})
+	if isNonFastForward(err) {
+		return fmt.Errorf("the mirror has commits the store doesn't, fix it by hand: %v", err)
+	}
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// PushRemotes pushes the local branch to every remote, or only to the ones
+// pushed on every commit unless sync. Each failure is reported on its own, the
+// returned error lists the remotes that failed.
+func PushRemotes(repo *git.Repository, sync bool, keys *Keyring) error {
+	failed := []string{}
+	for _, remote := range keys.Remotes {
+		if !sync && remote.Push == PUSH_SYNC {
+			continue
+		}
+		err := pushRemote(repo, remote, keys)
+		if err != nil {
+			log.Printf("Couldn't push to %v: %v\n", remote.Name, err)
+			failed = append(failed, remote.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("couldn't push to %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
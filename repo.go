@@ -2,22 +2,30 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/samuel-soubeyran/gojsonpointer"
 	"gopkg.in/src-d/go-git.v4"
-	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 const (
 	REPO_FOLDER_NAME  = "repo"
 	STORE_FOLDER_NAME = "store"
+	META_FOLDER_NAME  = ".vstore"
+	STORE_KEY_FILE    = "storekey"
 	AUTHOR_NAME       = "vstore"
 	AUTHOR_EMAIL      = ""
+	// head of the repository before the last pull that brought changes
+	ORIG_HEAD = plumbing.ReferenceName("ORIG_HEAD")
+	// location the store key file is sealed for
+	STORE_KEY_LOCATION = META_FOLDER_NAME + "/" + STORE_KEY_FILE
 )
 
 func GetStorePath() (string, error) {
@@ -27,6 +35,20 @@ func GetStorePath() (string, error) {
 	}
 	return filepath.Join(path, REPO_FOLDER_NAME, STORE_FOLDER_NAME), nil
 }
+// ObjectLocation returns the path of a content file relative to the store,
+// the location its content is sealed for.
+func ObjectLocation(path string) (string, error) {
+	storepath, err := GetStorePath()
+	if err != nil {
+		return "", err
+	}
+	relpath, err := filepath.Rel(storepath, path)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't get a relative path from %v with base %v", path, storepath))
+		return "", err
+	}
+	return filepath.ToSlash(relpath), nil
+}
 func GetRepoPath() (string, error) {
 	path, err := GetRootPath()
 	if err != nil {
@@ -35,7 +57,36 @@ func GetRepoPath() (string, error) {
 	return filepath.Join(path, REPO_FOLDER_NAME), nil
 }
 
-func UpdateStore(remote string) error {
+// GetMetaPath returns the folder holding the committed store metadata.
+func GetMetaPath() (string, error) {
+	path, err := GetRepoPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, META_FOLDER_NAME), nil
+}
+func GetStoreKeyPath() (string, error) {
+	path, err := GetMetaPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, STORE_KEY_FILE), nil
+}
+func CreateMetaDir() error {
+	path, err := GetMetaPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(path, os.ModePerm)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't create metadata directory %v", path))
+	}
+	return err
+}
+
+// UpdateStore pulls the remote changes, merging them with the local commits
+// when the branches diverged, or clones the store on first use.
+func UpdateStore(remote string, keys *Keyring) error {
 	path, err := GetRepoPath()
 	if err != nil {
 		return err
@@ -43,40 +94,72 @@ func UpdateStore(remote string) error {
 	_, err = os.Stat(path)
 	if err != nil {
 		if os.IsNotExist(err) {
+			if Offline {
+				return errors.New("the store was never cloned, it can't be created offline")
+			}
+			if remote == "" {
+				return errors.New("no store yet, create one with vstore init --local or set a remote")
+			}
 			// store does not exist
-			return CreateStore(remote)
+			return CreateStore(remote, keys)
 		}
 		return err
 	}
+	if Offline {
+		return nil
+	}
 	repo, err := git.PlainOpen(path)
 	if err != nil {
 		HandleErr(err, fmt.Sprintf("Couldn't open the repository at path %v", path))
 		return err
 	}
-	worktree, err := repo.Worktree()
+	if !HasRemote(repo) {
+		// local store
+		return nil
+	}
+	auth, err := keys.RepoAuth(repo)
 	if err != nil {
-		HandleErr(err, "Couldn't get the repository worktree")
-		return err
+		log.Println("Couldn't set up the remote authentication, working with the local store:", err)
+		return nil
 	}
-	err = worktree.Pull(&git.PullOptions{})
+	err = repo.Fetch(&git.FetchOptions{RemoteName: REMOTE_NAME, Auth: auth})
 	if err != nil && err != git.NoErrAlreadyUpToDate {
-		log.Println("Couldn't pull the worktree", err)
+		log.Println("Couldn't fetch the remote, working with the local store:", err)
+		return nil
+	}
+	err = VerifyIncoming(repo)
+	if err != nil {
+		HandleErr(err, "Refusing the remote changes")
+		return err
+	}
+	// merge what was verified rather than pulling, which would fetch again
+	merged, err := mergeRemote(repo, keys)
+	if err == nil && merged {
+		err = PushRemotes(repo, false, keys)
+	}
+	if err != nil {
+		log.Println("Couldn't merge the remote changes, working with the local store:", err)
 	}
 	return nil
 }
 
-func CreateStore(remote string) error {
+func CreateStore(remote string, keys *Keyring) error {
 	dirPath, err := GetRepoPath()
 	if err != nil {
 		return err
 	}
+	auth, err := keys.GitAuth(remote)
+	if err != nil {
+		return err
+	}
 	err = os.Mkdir(dirPath, os.ModePerm)
 	if err != nil && !os.IsExist(err) {
 		HandleErr(err, "Couldn't create the repo directory")
 		return err
 	}
 	_, err = git.PlainClone(dirPath, false, &git.CloneOptions{
-		URL: remote,
+		URL:  remote,
+		Auth: auth,
 	})
 	if err != nil {
 		HandleErr(err, "Couldn't clone the repository from remote")
@@ -84,8 +167,12 @@ func CreateStore(remote string) error {
 	}
 	return nil
 }
-func GetRawJsonContent(path string, masterPassword string) ([]byte, error) {
-	file, err := os.Open(path)
+func GetRawJsonContent(path string, keys *Keyring) ([]byte, error) {
+	physical, err := PhysicalPath(path, keys, false)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(physical)
 	if err != nil {
 		return nil, err
 	} else {
@@ -95,78 +182,179 @@ func GetRawJsonContent(path string, masterPassword string) ([]byte, error) {
 			HandleErr(err, fmt.Sprintf("Couldn't read content file at path %v", path))
 			return nil, err
 		}
-		var salt [PW_SALT_BYTES]byte
-		copy(salt[:], b[:32])
-		b = b[32:]
-		key := MakeKey([]byte(masterPassword), salt)
+		location, err := ObjectLocation(path)
+		if err != nil {
+			return nil, err
+		}
 		// decode to json object
-		return Decrypt(b, &key)
+		return keys.Open(b, location)
 	}
 }
-func GetJsonContent(path string, masterPassword string) (map[string]interface{}, error) {
+// WriteRawJsonContent encrypts rawjson and overwrites the content file at
+// path with it. The change is not committed.
+func WriteRawJsonContent(path string, rawjson []byte, keys *Keyring) error {
+	location, err := ObjectLocation(path)
+	if err != nil {
+		return err
+	}
+	recipients, err := RecipientsFor(location, keys)
+	if err != nil {
+		return err
+	}
+	// encrypt
+	encrypted, err := keys.SealFor(rawjson, location, recipients)
+	if err != nil {
+		return err
+	}
+	physical, err := PhysicalPath(path, keys, true)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(physical), os.ModePerm)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't create dirs %v", physical))
+		return err
+	}
+	// overwrite file with new encrypted content
+	err = ioutil.WriteFile(physical, encrypted, 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write content file at path %v", path))
+	}
+	return err
+}
+func GetJsonContent(path string, keys *Keyring) (map[string]interface{}, error) {
 	// read file content
 	jsonDocument := map[string]interface{}{}
-	rawjson, err := GetRawJsonContent(path, masterPassword)
+	rawjson, err := GetRawJsonContent(path, keys)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return jsonDocument, nil
 		}
 		return nil, err
 	}
-	err = json.Unmarshal(rawjson, &jsonDocument)
+	err = UnmarshalJson(rawjson, &jsonDocument)
 	if err != nil {
 		HandleErr(err, "Couldn't read content file as JSON object")
 		return nil, err
 	}
 	return jsonDocument, err
 }
-func StoreUpdateRemote(path string) error {
-	// git commit and push
+func RepoRelPath(path string) (string, error) {
 	repoPath, err := GetRepoPath()
+	if err != nil {
+		return "", err
+	}
+	relpath, err := filepath.Rel(repoPath, path)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't find rel path from %v for path %v", repoPath, path))
+		return "", err
+	}
+	return relpath, nil
+}
+// StoreUpdateRemote commits and pushes the content written at the logical
+// path by operation, at pointer when it changed a single value.
+func StoreUpdateRemote(path string, operation string, pointer string, keys *Keyring) error {
+	physical, err := PhysicalPath(path, keys, false)
 	if err != nil {
 		return err
 	}
-	repo, err := git.PlainOpen(repoPath)
+	relpath, err := RepoRelPath(path)
 	if err != nil {
-		HandleErr(err, fmt.Sprintf("Couldn't open repo at path %v", repoPath))
 		return err
 	}
-	worktree, err := repo.Worktree()
+	location, err := ObjectLocation(path)
 	if err != nil {
-		HandleErr(err, "Couldn't get worktree")
 		return err
 	}
-	relpath, err := filepath.Rel(repoPath, path)
+	message := fmt.Sprintf("Update content at %s", relpath)
+	if IsRedacted(location, keys) {
+		message = "Update content"
+	}
+	message = keys.CommitMessage(operation, location, pointer, message)
+	return StoreCommit([]string{physical}, message, keys)
+}
+
+// StoreCommit stages the files at paths, along with the index when it
+// changed, commits them in a single commit and pushes it. Within a
+// transaction, the commit is deferred to the end of the transaction.
+func StoreCommit(paths []string, message string, keys *Keyring) error {
+	if keys.tx != nil {
+		keys.tx.stage(paths, message)
+		return nil
+	}
+	indexPath, err := SaveIndex(keys)
 	if err != nil {
-		HandleErr(err, fmt.Sprintf("Couldn't find rel path from %v for path %v", repoPath, path))
 		return err
 	}
-	_, err = worktree.Add(relpath)
+	if indexPath != "" {
+		paths = append(paths, indexPath)
+	}
+	// a store key created for this change is committed with it
+	storeKeyPath, err := GetStoreKeyPath()
+	if err != nil {
+		return err
+	}
+	exists, err := PathExists(storeKeyPath)
 	if err != nil {
-		HandleErr(err, fmt.Sprintf("Couldn't add file %v to index", relpath))
 		return err
 	}
-	now := time.Now()
-	signature := object.Signature{
-		Name:  AUTHOR_NAME,
-		Email: AUTHOR_EMAIL,
-		When:  now,
+	if exists {
+		paths = append(paths, storeKeyPath)
 	}
-	_, err = worktree.Commit(fmt.Sprintf("Update content at %s", relpath), &git.CommitOptions{
-		Author: &signature,
+	// git commit and push
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return err
+	}
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't open repo at path %v", repoPath))
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		HandleErr(err, "Couldn't get worktree")
+		return err
+	}
+	for _, path := range paths {
+		relpath, err := filepath.Rel(repoPath, path)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't find rel path from %v for path %v", repoPath, path))
+			return err
+		}
+		_, err = worktree.Add(relpath)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't add file %v to index", relpath))
+			return err
+		}
+	}
+	signature := keys.Signature(time.Now())
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author:  &signature,
+		SignKey: keys.SignKey,
 	})
 	if err != nil {
 		HandleErr(err, "Couldn't commit content change")
 		return err
 	}
-	err = repo.Push(&git.PushOptions{})
+	if len(keys.Remotes) == 0 {
+		return nil
+	}
+	if Offline {
+		fmt.Println("Committed locally, run vstore sync to push")
+		return nil
+	}
+	err = PushRemotes(repo, false, keys)
 	if err != nil {
-		HandleErr(err, "Couldn't push commit to remote")
+		// the commit is kept in the outbox
+		log.Println("The commit is kept locally, run vstore sync later:", err)
 	}
-  return err
+	return nil
 }
-func StoreSetValue(path string, property string, value string, masterPassword string) error {
-	jsonDocument, err := GetJsonContent(path, masterPassword)
+// StoreSetValue sets the JSON value at the pointer property and pushes the
+// change.
+func StoreSetValue(path string, property string, value interface{}, keys *Keyring) error {
+	jsonDocument, err := GetJsonContent(path, keys)
 	if err != nil {
 		return err
 	}
@@ -186,39 +374,181 @@ func StoreSetValue(path string, property string, value string, masterPassword st
 		HandleErr(err, "Couldn't marshal JSON content")
 		return err
 	}
-	// encrypt
-	salt, err := GenerateSalt()
+	err = WriteRawJsonContent(path, nb, keys)
 	if err != nil {
 		return err
 	}
-	key := MakeKey([]byte(masterPassword), salt)
-	encrypted, err := Encrypt(nb, &key)
+	return StoreUpdateRemote(path, "set", property, keys)
+}
+
+// StoreRemoveObject deletes the content file at path and pushes the removal.
+func StoreRemoveObject(path string, keys *Keyring) error {
+	removed, err := RemoveObject(path, keys)
 	if err != nil {
 		return err
 	}
-	// overwrite file with new encrypted content
-	err = ioutil.WriteFile(path, append(salt[:], encrypted...), 0644)
+	relpath, err := RepoRelPath(path)
+	if err != nil {
+		return err
+	}
+	location, err := ObjectLocation(path)
 	if err != nil {
-		HandleErr(err, fmt.Sprintf("Couldn't write content file at path %v", path))
 		return err
 	}
-	return StoreUpdateRemote(path)
+	message := fmt.Sprintf("Remove content at %s", relpath)
+	if IsRedacted(location, keys) {
+		message = "Remove content"
+	}
+	message = keys.CommitMessage("remove", location, "", message)
+	return StoreCommit([]string{removed}, message, keys)
 }
 
-func StoreGetValue(path string, property string, masterPassword string) (string, error) {
-	jsonDocument, err := GetJsonContent(path, masterPassword)
+// StoreGetValue returns the JSON value at the pointer property.
+func StoreGetValue(path string, property string, keys *Keyring) (interface{}, error) {
+	jsonDocument, err := GetJsonContent(path, keys)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	// get value
 	pointer, err := gojsonpointer.NewJsonPointer(property)
 	if err != nil {
 		HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", property))
-		return "", err
+		return nil, err
 	}
 	value, _, err := pointer.Get(jsonDocument)
 	if err != nil {
 		HandleErr(err, fmt.Sprintf("Couldn't get value at %v for content file at path %v", property, path))
+		return nil, err
+	}
+	return value, nil
+}
+
+// deleteValue removes the value at the pointer property from jsonDocument.
+func deleteValue(jsonDocument map[string]interface{}, property string) error {
+	if property == "" {
+		return errors.New("can't unset the whole document, remove the file instead")
+	}
+	pointer, err := gojsonpointer.NewJsonPointer(property)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", property))
+		return err
+	}
+	tokens, err := pointerTokens(property)
+	if err != nil {
+		return err
+	}
+	parent, err := pointerGet(jsonDocument, tokens[:len(tokens)-1])
+	if err != nil {
+		return err
+	}
+	if _, ok := parent.([]interface{}); ok {
+		// gojsonpointer moves the last element in place of the deleted one,
+		// keep the order of arrays
+		_, err = patchRemove(jsonDocument, tokens)
+		return err
+	}
+	_, err = pointer.Delete(jsonDocument)
+	return err
+}
+
+func writeJsonContent(path string, jsonDocument map[string]interface{}, keys *Keyring) error {
+	nb, err := json.Marshal(jsonDocument)
+	if err != nil {
+		HandleErr(err, "Couldn't marshal JSON content")
+		return err
+	}
+	return WriteRawJsonContent(path, nb, keys)
+}
+
+// StoreUnsetValue deletes the value at the pointer property and pushes the
+// change.
+func StoreUnsetValue(path string, property string, keys *Keyring) error {
+	jsonDocument, err := GetJsonContent(path, keys)
+	if err != nil {
+		return err
+	}
+	err = deleteValue(jsonDocument, property)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't unset %v in content file at path %v", property, path))
+		return err
+	}
+	err = writeJsonContent(path, jsonDocument, keys)
+	if err != nil {
+		return err
+	}
+	return StoreUpdateRemote(path, "unset", property, keys)
+}
+
+// StoreMoveValue moves the value at the pointer from to the pointer to in the
+// same content file.
+func StoreMoveValue(path string, from string, to string, keys *Keyring) error {
+	jsonDocument, err := GetJsonContent(path, keys)
+	if err != nil {
+		return err
+	}
+	source, err := gojsonpointer.NewJsonPointer(from)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", from))
+		return err
+	}
+	target, err := gojsonpointer.NewJsonPointer(to)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", to))
+		return err
+	}
+	if strings.HasPrefix(to+"/", from+"/") {
+		return fmt.Errorf("can't move %v into itself", from)
+	}
+	_, _, err = target.Get(jsonDocument)
+	if err == nil {
+		return fmt.Errorf("%v already exists", to)
+	}
+	value, _, err := source.Get(jsonDocument)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't get value at %v for content file at path %v", from, path))
+		return err
+	}
+	err = deleteValue(jsonDocument, from)
+	if err != nil {
+		return err
+	}
+	_, err = target.Set(jsonDocument, value)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't set value at %v for content file at path %v", to, path))
+		return err
+	}
+	err = writeJsonContent(path, jsonDocument, keys)
+	if err != nil {
+		return err
+	}
+	return StoreUpdateRemote(path, "mvkey", to, keys)
+}
+
+// StoreCopyValue copies the value at the pointer from of the content file at
+// path to the pointer to of the content file at target, which may be the same
+// file.
+func StoreCopyValue(path string, from string, target string, to string, keys *Keyring) error {
+	value, err := StoreGetValue(path, from, keys)
+	if err != nil {
+		return err
+	}
+	jsonDocument, err := GetJsonContent(target, keys)
+	if err != nil {
+		return err
+	}
+	pointer, err := gojsonpointer.NewJsonPointer(to)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", to))
+		return err
+	}
+	_, err = pointer.Set(jsonDocument, value)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't set value at %v for content file at path %v", to, target))
+		return err
+	}
+	err = writeJsonContent(target, jsonDocument, keys)
+	if err != nil {
+		return err
 	}
-	return value.(string), nil
+	return StoreUpdateRemote(target, "cpkey", to, keys)
 }
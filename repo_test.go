@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/src-d/go-git.v4"
+)
+
+func TestDeleteValue(t *testing.T) {
+	doc := decodeJson(t, `{"pass": "x", "nested": {"a": 1, "b": 2}, "list": ["a", "b", "c"]}`).(map[string]interface{})
+	for _, property := range []string{"/pass", "/nested/a", "/list/0"} {
+		err := deleteValue(doc, property)
+		if err != nil {
+			t.Fatal(property, err)
+		}
+	}
+	expected := decodeJson(t, `{"nested": {"b": 2}, "list": ["b", "c"]}`)
+	if !reflect.DeepEqual(doc, expected) {
+		t.Error("Expecting", expected, "got", doc)
+	}
+	for _, property := range []string{"", "/missing", "/list/5"} {
+		if deleteValue(doc, property) == nil {
+			t.Error("Expecting an error deleting", property)
+		}
+	}
+}
+
+// testStore makes an empty local store in a temporary directory and returns
+// its path.
+func testStore(t *testing.T) string {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = git.PlainInit(repoPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storepath, err := GetStorePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storepath
+}
+
+func TestSetValueKeepsNumbers(t *testing.T) {
+	storepath := testStore(t)
+	keys := testKeyring(t)
+	path := filepath.Join(storepath, "numbers")
+	big := json.Number("12345678901234567890.25")
+	err := StoreSetValue(path, "/big", big, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = StoreSetValue(path, "/other", "x", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := GetJsonContent(path, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["big"] != big {
+		t.Error("Expecting the number to keep its exact text, got", doc["big"])
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samuel-soubeyran/gojsonpointer"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// Restores and undos never rewrite history, they write the old content as a
+// new commit.
+const UNDOES_TRAILER = "Undoes:"
+
+func shortHash(commit *object.Commit) string {
+	return commit.Hash.String()[:8]
+}
+
+// StoreRestore writes back the object at the logical path, or only the value
+// at the pointer property when it isn't nil, as it was at the revision or
+// date rev. A value missing at rev is removed.
+func StoreRestore(path string, property *string, rev string, keys *Keyring) error {
+	current, err := contentRelPath(path, keys)
+	if err != nil {
+		return err
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	target, err := ResolveAt(repo, rev)
+	if err != nil {
+		return err
+	}
+	rawjson, err := rawJsonContentAtCommit(repo, current, target, keys)
+	if err != nil {
+		return err
+	}
+	if property != nil {
+		var old interface{}
+		err = UnmarshalJson(rawjson, &old)
+		if err != nil {
+			HandleErr(err, "Couldn't read content file as JSON object")
+			return err
+		}
+		jsonDocument, err := GetJsonContent(path, keys)
+		if err != nil {
+			return err
+		}
+		tokens, err := pointerTokens(*property)
+		if err != nil {
+			return err
+		}
+		pointer, err := gojsonpointer.NewJsonPointer(*property)
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("%v is not a valid JSON pointer", *property))
+			return err
+		}
+		value, err := pointerGet(old, tokens)
+		if err != nil {
+			// the value didn't exist yet
+			err = deleteValue(jsonDocument, *property)
+		} else {
+			_, err = pointer.Set(jsonDocument, value)
+		}
+		if err != nil {
+			HandleErr(err, fmt.Sprintf("Couldn't restore %v at path %v", *property, path))
+			return err
+		}
+		rawjson, err = json.Marshal(jsonDocument)
+		if err != nil {
+			return err
+		}
+	}
+	err = WriteRawJsonContent(path, rawjson, keys)
+	if err != nil {
+		return err
+	}
+	physical, err := PhysicalPath(path, keys, false)
+	if err != nil {
+		return err
+	}
+	relpath, err := RepoRelPath(path)
+	if err != nil {
+		return err
+	}
+	location, err := ObjectLocation(path)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Restore content at %s from %s", relpath, shortHash(target))
+	if IsRedacted(location, keys) {
+		message = fmt.Sprintf("Restore content from %s", shortHash(target))
+	}
+	pointer := ""
+	if property != nil {
+		pointer = *property
+	}
+	message = keys.CommitMessage("restore", location, pointer, message)
+	return StoreCommit([]string{physical}, message, keys)
+}
+
+// lastVersionOf returns the last commit, at or before from, holding an object
+// at the store relative location, and the path of its content file.
+func lastVersionOf(repo *git.Repository, location string, from *object.Commit, keys *Keyring) (*object.Commit, string, error) {
+	var found *object.Commit
+	relpath := ""
+	started := from == nil
+	err := walkObjectHistory(repo, "", false, func(commit *object.Commit, _ string, _ bool) error {
+		if !started && commit.Hash != from.Hash {
+			return nil
+		}
+		started = true
+		candidate := STORE_FOLDER_NAME + "/" + location
+		index, err := indexAt(commit, keys)
+		if err != nil {
+			return err
+		}
+		if index != nil {
+			id, ok := index.Ids[location]
+			if !ok {
+				return nil
+			}
+			candidate = STORE_FOLDER_NAME + "/" + id
+		}
+		if _, ok := fileHash(commit, candidate); ok {
+			found, relpath = commit, candidate
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if found == nil {
+		return nil, "", fmt.Errorf("%v is not in the history of the store", location)
+	}
+	return found, relpath, nil
+}
+
+// StoreRestoreDeleted writes back a deleted object at the store relative
+// path name, as it was last, or at rev when it isn't empty.
+func StoreRestoreDeleted(name string, rev string, keys *Keyring) error {
+	location := strings.Trim(filepath.ToSlash(name), "/")
+	storepath, err := GetStorePath()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(storepath, filepath.FromSlash(location))
+	exists, err := ObjectExists(path, keys)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%v exists, restore it with --to", location)
+	}
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	var from *object.Commit
+	if rev != "" {
+		from, err = ResolveAt(repo, rev)
+		if err != nil {
+			return err
+		}
+	}
+	commit, relpath, err := lastVersionOf(repo, location, from, keys)
+	if err != nil {
+		return err
+	}
+	rawjson, err := ContentAt(commit, relpath, keys)
+	if err != nil {
+		return err
+	}
+	err = WriteRawJsonContent(path, rawjson, keys)
+	if err != nil {
+		return err
+	}
+	physical, err := PhysicalPath(path, keys, false)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Restore deleted content at %s from %s", STORE_FOLDER_NAME+"/"+location, shortHash(commit))
+	if IsRedacted(location, keys) {
+		message = fmt.Sprintf("Restore deleted content from %s", shortHash(commit))
+	}
+	message = keys.CommitMessage("restore", location, "", message)
+	return StoreCommit([]string{physical}, message, keys)
+}
+
+// lastOwnCommit returns the last commit with a single parent authored by
+// the user through vstore, once the user has set an author email.
+func lastOwnCommit(repo *git.Repository, keys *Keyring) (*object.Commit, error) {
+	// the default author is shared by every user of the store
+	if keys.AuthorEmail == "" {
+		return nil, errors.New("no author email to find your commits, run vstore config author-email <email> first")
+	}
+	var found *object.Commit
+	err := walkObjectHistory(repo, "", false, func(commit *object.Commit, _ string, _ bool) error {
+		// merges and the first commit have no single parent to go back to
+		if commit.NumParents() == 1 && keys.IsAuthor(commit) {
+			found = commit
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, errors.New("no commit of yours to undo")
+	}
+	return found, nil
+}
+
+// undoableNames returns the names of the files changed by commit from
+// parent, refusing the ones changed again at head.
+func undoableNames(commit *object.Commit, parent *object.Commit, head *object.Commit) ([]string, error) {
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't list the changes of commit %v", shortHash(commit)))
+		return nil, err
+	}
+	names := []string{}
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		if name == STORE_KEY_LOCATION {
+			return nil, errors.New("can't undo a change of the store key")
+		}
+		undone, inCommit := fileHash(commit, name)
+		current, inHead := fileHash(head, name)
+		if inCommit != inHead || undone != current {
+			return nil, fmt.Errorf("%v changed since commit %v, restore it instead", name, shortHash(commit))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Undo reverts the last commit authored by the user with a new commit, putting
+// back the files it changed as they were in its parent. Files changed again
+// since then are not overwritten.
+func Undo(keys *Keyring) error {
+	repo, err := OpenStoreRepo()
+	if err != nil {
+		return err
+	}
+	commit, err := lastOwnCommit(repo, keys)
+	if err != nil {
+		return err
+	}
+	parent, err := commit.Parent(0)
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	names, err := undoableNames(commit, parent, headCommit)
+	if err != nil {
+		return err
+	}
+	repoPath, err := GetRepoPath()
+	if err != nil {
+		return err
+	}
+	paths := []string{}
+	for _, name := range names {
+		path := filepath.Join(repoPath, filepath.FromSlash(name))
+		file, err := parent.File(name)
+		if err != nil {
+			err = os.Remove(path)
+			if err != nil && !os.IsNotExist(err) {
+				HandleErr(err, fmt.Sprintf("Couldn't remove %v", name))
+				return err
+			}
+		} else {
+			b, err := blobContent(file)
+			if err != nil {
+				return err
+			}
+			err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+			if err != nil {
+				return err
+			}
+			err = ioutil.WriteFile(path, b, 0644)
+			if err != nil {
+				HandleErr(err, fmt.Sprintf("Couldn't write %v", name))
+				return err
+			}
+		}
+		paths = append(paths, path)
+	}
+	// the index may have been put back
+	keys.index = nil
+	subject := strings.SplitN(commit.Message, "\n", 2)[0]
+	// the template names the location only when a single one was undone
+	location := ""
+	if len(names) == 1 && strings.HasPrefix(names[0], STORE_FOLDER_NAME+"/") {
+		location = strings.TrimPrefix(names[0], STORE_FOLDER_NAME+"/")
+	}
+	message := fmt.Sprintf("Undo \"%s\"\n\n%s %s", subject, UNDOES_TRAILER, commit.Hash)
+	message = keys.CommitMessage("undo", location, "", message)
+	return StoreCommit(paths, message, keys)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestLastVersionOf(t *testing.T) {
+	repo, commit := testRepo(t)
+	created := commit("create", map[string]string{"store/a": "1"}, nil, nil)
+	updated := commit("update", map[string]string{"store/a": "2"}, nil, nil)
+	commit("remove", map[string]string{"store/other": "1"}, []string{"store/a"}, nil)
+	keys := testKeyring(t)
+	found, relpath, err := lastVersionOf(repo, "a", nil, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Hash != updated || relpath != "store/a" {
+		t.Error("Expecting the last version before the removal, got", found.Message, relpath)
+	}
+	from, err := repo.CommitObject(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, _, err = lastVersionOf(repo, "a", from, keys)
+	if err != nil || found.Hash != created {
+		t.Error("Expecting the version at the revision, got", found, err)
+	}
+	_, _, err = lastVersionOf(repo, "missing", nil, keys)
+	if err == nil {
+		t.Error("Expecting an error for an object never in the store")
+	}
+}
+
+func TestUndoableNames(t *testing.T) {
+	repo, commit := testRepo(t)
+	commits := []*object.Commit{}
+	for _, write := range []map[string]string{
+		{"store/a": "1", "store/b": "1"},
+		{"store/a": "2"},
+		{"store/b": "2"},
+		{"store/a": "3"},
+	} {
+		c, err := repo.CommitObject(commit("change", write, nil, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, c)
+	}
+	names, err := undoableNames(commits[1], commits[0], commits[2])
+	if err != nil || !reflect.DeepEqual(names, []string{"store/a"}) {
+		t.Error("Expecting a change left alone since to be undoable, got", names, err)
+	}
+	_, err = undoableNames(commits[1], commits[0], commits[3])
+	if err == nil || !strings.Contains(err.Error(), "changed since") {
+		t.Error("Expecting a change made again since to be refused, got", err)
+	}
+}
+
+func TestLastOwnCommit(t *testing.T) {
+	repo, commit := testRepo(t)
+	theirs := commit("theirs", map[string]string{"store/a": "1"}, nil, nil)
+	keys := testKeyring(t)
+	_, err := lastOwnCommit(repo, keys)
+	if err == nil || !strings.Contains(err.Error(), "author-email") {
+		t.Error("Expecting undo to require an author email, got", err)
+	}
+	keys.AuthorEmail = "me@example.org"
+	_, err = lastOwnCommit(repo, keys)
+	if err == nil || !strings.Contains(err.Error(), "no commit of yours") {
+		t.Error("Expecting the default author not to be the user, got", err)
+	}
+	// a commit and a merge of the user on top
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tip, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := func(parents ...plumbing.Hash) plumbing.Hash {
+		signature := keys.Signature(tip.Author.When.Add(time.Duration(len(parents)) * time.Minute))
+		c := &object.Commit{Author: signature, Committer: signature, Message: "own", TreeHash: tip.TreeHash, ParentHashes: parents}
+		obj := repo.Storer.NewEncodedObject()
+		err := c.Encode(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := repo.Storer.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	single := own(theirs)
+	own(single, theirs)
+	found, err := lastOwnCommit(repo, keys)
+	if err != nil || found.Hash != single {
+		t.Error("Expecting the merge to be skipped for", single, "got", found, err)
+	}
+}
@@ -13,8 +13,73 @@ const (
 )
 
 type usersettings struct {
-	Remote    string `json:"remote"`
-	MasterKey string `json:"master_key"`
+	// Remote is the URL of origin, the remote the store is cloned and pulled
+	// from.
+	Remote    string       `json:"remote"`
+	MasterKey string       `json:"master_key"`
+	Kdf       *kdfsettings `json:"kdf,omitempty"`
+	// Padding is the padding scheme of newly written files: none, padme or
+	// pow2. Defaults to padme.
+	Padding string `json:"padding,omitempty"`
+	// Identity is the X25519 private key of the user and User the name its
+	// public key is registered under.
+	Identity string `json:"identity,omitempty"`
+	User     string `json:"user,omitempty"`
+	// Format is the format of newly written objects: vstore or age.
+	// Defaults to vstore.
+	Format string `json:"format,omitempty"`
+	// Auth is how to authenticate to the remote, go-git defaults when
+	// empty.
+	Auth *authsettings `json:"auth,omitempty"`
+	// Remotes are the named remotes the store is pushed to besides origin,
+	// and origin when its push policy isn't the default.
+	Remotes []remotesettings `json:"remotes,omitempty"`
+	// SignKey is the armored OpenPGP private key signing the commits.
+	SignKey string `json:"sign_key,omitempty"`
+	// AuthorName and AuthorEmail author the commits, vstore by default.
+	AuthorName  string `json:"author_name,omitempty"`
+	AuthorEmail string `json:"author_email,omitempty"`
+	// MessageTemplate formats the commit messages, see CommitMessage.
+	MessageTemplate string `json:"message_template,omitempty"`
+	// Redact are the path patterns kept out of commit messages.
+	Redact []string `json:"redact,omitempty"`
+}
+
+// remotesettings is a remote the store is pushed to, on every commit or on
+// sync only.
+type remotesettings struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Push string `json:"push"`
+	// Auth is how to authenticate to a mirror, go-git defaults when nil.
+	Auth *authsettings `json:"auth,omitempty"`
+}
+
+// RemoteList returns every remote of the store, origin first.
+func (settings usersettings) RemoteList() []remotesettings {
+	remotes := []remotesettings{}
+	if settings.Remote != "" {
+		origin := remotesettings{Name: REMOTE_NAME, URL: settings.Remote, Push: PUSH_ALWAYS}
+		for _, remote := range settings.Remotes {
+			if remote.Name == REMOTE_NAME {
+				origin.Push = remote.Push
+			}
+		}
+		remotes = append(remotes, origin)
+	}
+	for _, remote := range settings.Remotes {
+		if remote.Name != REMOTE_NAME {
+			remotes = append(remotes, remote)
+		}
+	}
+	return remotes
+}
+
+// kdfsettings holds the Argon2id costs used for newly written files.
+type kdfsettings struct {
+	Time        uint32 `json:"time"`
+	Memory      uint32 `json:"memory"`
+	Parallelism uint8  `json:"parallelism"`
 }
 
 func GetSettingsFilePath() (string, error) {
@@ -40,18 +105,11 @@ func GetSettings(password string) (usersettings, error) {
 	}
 	defer file.Close()
 	b, err := ioutil.ReadAll(file)
-	var salt [PW_SALT_BYTES]byte
-	copy(salt[:], b[:PW_SALT_BYTES])
-	b = b[PW_SALT_BYTES:]
-	if err != nil {
-		return usersettings{}, err
-	}
-	key := MakeKey([]byte(password), salt)
 	if err != nil {
 		HandleErr(err, "Couldn't read settings file")
 		return usersettings{}, err
 	}
-	rawSettings, err := Decrypt(b, &key)
+	rawSettings, err := OpenEnvelope(b, PasswordKey(password), ENCRYPTED_SETTINGS_FILE)
 	if err != nil {
 		HandleErr(err, "Couldn't decrypt settings file content")
 		return usersettings{}, err
@@ -60,8 +118,25 @@ func GetSettings(password string) (usersettings, error) {
 	err = json.Unmarshal(rawSettings, &userSettings)
 	if err != nil {
 		HandleErr(err, "Couldn't read settings as JSON object")
+		return userSettings, err
 	}
-	return userSettings, err
+	// re-encrypt settings sealed with an outdated key derivation
+	kdf, err := EnvelopeKdf(b)
+	if err != nil {
+		return userSettings, err
+	}
+	target, err := NewKdfParams(userSettings.Kdf)
+	if err != nil {
+		return userSettings, err
+	}
+	if !SameKdfCost(kdf, target) {
+		err = CreateEncodedSettingsFile(password, userSettings)
+		if err != nil {
+			HandleErr(err, "Couldn't upgrade settings file key derivation")
+			return userSettings, err
+		}
+	}
+	return userSettings, nil
 }
 
 func CreateSettings(password string) (usersettings, error) {
@@ -70,27 +145,26 @@ func CreateSettings(password string) (usersettings, error) {
 	var remote string
 	fmt.Print("master key: ")
 	fmt.Scanln(&masterKey)
-	fmt.Print("remote: ")
+	fmt.Print("remote (empty for a local store): ")
 	fmt.Scanln(&remote)
-	salt, err := GenerateSalt()
-	if err != nil {
-		return usersettings{}, err
-	}
 	settings := usersettings{Remote: remote, MasterKey: masterKey}
-	err = CreateEncodedSettingsFile(password, salt, settings)
+	err := CreateEncodedSettingsFile(password, settings)
 	if err != nil {
 		return usersettings{}, err
 	}
 	return settings, nil
 }
 
-func CreateEncodedSettingsFile(password string, salt [PW_SALT_BYTES]byte, settings usersettings) error {
+func CreateEncodedSettingsFile(password string, settings usersettings) error {
 	b, err := json.Marshal(settings)
 	if err != nil {
 		return err
 	}
-	key := MakeKey([]byte(password), salt)
-	encrypted, err := Encrypt(b, &key)
+	kdf, err := NewKdfParams(settings.Kdf)
+	if err != nil {
+		return err
+	}
+	encrypted, err := SealEnvelope(b, kdf, PasswordKey(password), ENCRYPTED_SETTINGS_FILE, PADDING_NONE)
 	if err != nil {
 		HandleErr(err, "Couldn't encrypt user settings")
 		return err
@@ -99,12 +173,12 @@ func CreateEncodedSettingsFile(password string, salt [PW_SALT_BYTES]byte, settin
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(path, append(salt[:], encrypted...), 0644)
+	err = ioutil.WriteFile(path, encrypted, 0644)
 	if err != nil {
 		if os.IsNotExist(err) {
 			_, err = CreateRootDir()
 			if err == nil {
-				err = ioutil.WriteFile(path, append(salt[:], encrypted...), 0644)
+				err = ioutil.WriteFile(path, encrypted, 0644)
 			}
 		}
 		if err != nil {
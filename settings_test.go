@@ -16,9 +16,11 @@ func TestCreateEncodedSettingsFile(t *testing.T) {
 		t.Error("Couldn't delete salt file", err)
 	}
 
-	key := MakeKey([]byte("testpassword"), []byte("testsalt"))
 	settings := usersettings{Remote: "testremote", MasterKey: "testmasterkey"}
-	CreateEncodedSettingsFile(&key, settings)
+	err = CreateEncodedSettingsFile("testpassword", settings)
+	if err != nil {
+		t.Error("Couldn't create settings file", err)
+	}
 
 	file, err := os.Open(path)
 	if err != nil {
@@ -32,7 +34,7 @@ func TestCreateEncodedSettingsFile(t *testing.T) {
 	if len(b) == 0 {
 		t.Error("Expecting content of settings file to not be empty")
 	}
-	plaintext, err := Decrypt(b, &key)
+	plaintext, err := OpenEnvelope(b, PasswordKey("testpassword"), ENCRYPTED_SETTINGS_FILE)
 	if err != nil {
 		t.Error("Couldn't decrypt file content", err)
 	}
@@ -40,3 +42,22 @@ func TestCreateEncodedSettingsFile(t *testing.T) {
 		t.Error("Expecting file content to decrypt as the json settings object, got", string(plaintext))
 	}
 }
+
+func TestRemoteList(t *testing.T) {
+	settings := usersettings{Remote: "git@example.com:store.git"}
+	remotes := settings.RemoteList()
+	if len(remotes) != 1 || remotes[0].Name != REMOTE_NAME || remotes[0].Push != PUSH_ALWAYS {
+		t.Errorf("Unexpected remotes %v", remotes)
+	}
+	settings.Remotes = []remotesettings{
+		{Name: "nas", URL: "/mnt/nas/store.git", Push: PUSH_SYNC},
+		{Name: REMOTE_NAME, URL: "git@example.com:store.git", Push: PUSH_SYNC},
+	}
+	remotes = settings.RemoteList()
+	if len(remotes) != 2 || remotes[0].Name != REMOTE_NAME || remotes[0].Push != PUSH_SYNC || remotes[1].Name != "nas" {
+		t.Errorf("Unexpected remotes %v", remotes)
+	}
+	if len((usersettings{}).RemoteList()) != 0 {
+		t.Error("Expected no remote for a local store")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Shamir secret sharing over GF(2^8): every byte of the secret is the constant
+// term of a random polynomial of degree threshold-1, share x holds the
+// evaluations of these polynomials at x.
+const (
+	SHARE_PREFIX = "vstore-share"
+	SHARE_MAX    = 255
+)
+
+var gfExp [510]byte
+var gfLog [256]byte
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfExp[i+255] = x
+		gfLog[x] = byte(i)
+		// multiply by the generator 3 modulo x^8+x^4+x^3+x+1
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+}
+
+func gfMul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a byte, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+type share struct {
+	// Id tells the splits apart
+	Id        string
+	Threshold int
+	X         byte
+	Y         []byte
+}
+
+// ShamirSplit splits secret in n shares, any threshold of which rebuild it.
+func ShamirSplit(secret []byte, n int, threshold int) ([]share, error) {
+	if threshold < 2 || threshold > n || n > SHARE_MAX {
+		return nil, fmt.Errorf("invalid number of shares %d with threshold %d", n, threshold)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+	id := make([]byte, 4)
+	_, err := io.ReadFull(rand.Reader, id)
+	if err != nil {
+		HandleErr(err, "Couldn't get enough entropy")
+		return nil, err
+	}
+	shares := make([]share, n)
+	for i := range shares {
+		shares[i] = share{Id: hex.EncodeToString(id), Threshold: threshold, X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+	coefficients := make([]byte, threshold-1)
+	for b, s := range secret {
+		_, err := io.ReadFull(rand.Reader, coefficients)
+		if err != nil {
+			HandleErr(err, "Couldn't get enough entropy")
+			return nil, err
+		}
+		for i := range shares {
+			// Horner evaluation of s + c0 x + c1 x^2 ...
+			y := byte(0)
+			for j := len(coefficients) - 1; j >= 0; j-- {
+				y = gfMul(y^coefficients[j], shares[i].X)
+			}
+			shares[i].Y[b] = y ^ s
+		}
+	}
+	return shares, nil
+}
+
+// ShamirCombine rebuilds the secret from at least threshold shares.
+func ShamirCombine(shares []share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+	threshold := shares[0].Threshold
+	if threshold < 2 || threshold > SHARE_MAX {
+		return nil, fmt.Errorf("invalid share threshold %d", threshold)
+	}
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%d shares needed, got %d", threshold, len(shares))
+	}
+	shares = shares[:threshold]
+	seen := map[byte]bool{}
+	for _, s := range shares {
+		if s.Id != shares[0].Id || s.Threshold != threshold || len(s.Y) != len(shares[0].Y) {
+			return nil, errors.New("shares come from different splits")
+		}
+		if s.X == 0 || seen[s.X] {
+			return nil, fmt.Errorf("duplicate or invalid share %d", s.X)
+		}
+		seen[s.X] = true
+	}
+	secret := make([]byte, len(shares[0].Y))
+	for i, si := range shares {
+		// Lagrange basis polynomial of share i evaluated at 0
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(sj.X, sj.X^si.X))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.Y[b], basis)
+		}
+	}
+	return secret, nil
+}
+
+func shareChecksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:4])
+}
+
+// EncodeShare returns the printable form of a share,
+// vstore-share-<id>-<threshold>-<x>-<hex y>-<checksum>.
+func EncodeShare(s share) string {
+	body := fmt.Sprintf("%s-%s-%d-%d-%s", SHARE_PREFIX, s.Id, s.Threshold, s.X, hex.EncodeToString(s.Y))
+	return body + "-" + shareChecksum(body)
+}
+
+func DecodeShare(encoded string) (share, error) {
+	encoded = strings.TrimSpace(encoded)
+	i := strings.LastIndex(encoded, "-")
+	if i < 0 || !strings.HasPrefix(encoded, SHARE_PREFIX+"-") {
+		return share{}, errors.New("not a vstore share")
+	}
+	body := encoded[:i]
+	if shareChecksum(body) != encoded[i+1:] {
+		return share{}, errors.New("share checksum mismatch, check for typos")
+	}
+	fields := strings.Split(strings.TrimPrefix(body, SHARE_PREFIX+"-"), "-")
+	if len(fields) != 4 {
+		return share{}, errors.New("malformed share")
+	}
+	_, err := hex.DecodeString(fields[0])
+	if err != nil || len(fields[0]) != 8 {
+		return share{}, errors.New("malformed share id")
+	}
+	threshold, err := strconv.Atoi(fields[1])
+	if err != nil || threshold < 2 || threshold > SHARE_MAX {
+		return share{}, errors.New("malformed share threshold")
+	}
+	x, err := strconv.Atoi(fields[2])
+	if err != nil || x < 1 || x > SHARE_MAX {
+		return share{}, errors.New("malformed share index")
+	}
+	y, err := hex.DecodeString(fields[3])
+	if err != nil {
+		return share{}, err
+	}
+	return share{Id: fields[0], Threshold: threshold, X: byte(x), Y: y}, nil
+}
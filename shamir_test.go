@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestGfDiv(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if gfDiv(gfMul(byte(a), byte(b)), byte(b)) != byte(a) {
+				t.Fatal("Expecting division to invert multiplication for", a, b)
+			}
+		}
+	}
+}
+
+func TestShamirSplitCombine(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := ShamirSplit(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, picked := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4, 0}} {
+		subset := []share{}
+		for _, i := range picked {
+			decoded, err := DecodeShare(EncodeShare(shares[i]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			subset = append(subset, decoded)
+		}
+		combined, err := ShamirCombine(subset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Error("Expecting shares", picked, "to rebuild the secret, got", string(combined))
+		}
+	}
+	_, err = ShamirCombine(shares[:2])
+	if err == nil {
+		t.Error("Expecting an error below the threshold")
+	}
+	other, err := ShamirSplit(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ShamirCombine([]share{shares[0], shares[1], other[2]})
+	if err == nil {
+		t.Error("Expecting an error combining shares of different splits")
+	}
+}
+
+func TestDecodeShareChecksum(t *testing.T) {
+	shares, err := ShamirSplit([]byte("secret"), 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := []byte(EncodeShare(shares[0]))
+	encoded[len(SHARE_PREFIX)+6] ^= 1
+	_, err = DecodeShare(string(encoded))
+	if err == nil {
+		t.Error("Expecting a checksum error for a mistyped share")
+	}
+}
+
+func TestDecodeShareThreshold(t *testing.T) {
+	for _, threshold := range []int{0, 1, 256, 1 << 30} {
+		body := fmt.Sprintf("%s-00000000-%d-1-ab", SHARE_PREFIX, threshold)
+		_, err := DecodeShare(body + "-" + shareChecksum(body))
+		if err == nil {
+			t.Error("Expecting an error for threshold", threshold)
+		}
+	}
+	_, err := ShamirCombine([]share{{Threshold: 0, X: 1, Y: []byte{1}}})
+	if err == nil {
+		t.Error("Expecting an error combining shares with no threshold")
+	}
+}
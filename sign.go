@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+// Commits are signed with the OpenPGP key of the settings when there is one.
+// The public keys allowed to sign are committed in the trusted keys file.
+// Once a store has that file, every commit pulled must be signed by a key
+// trusted locally, or trusted by an earlier pulled commit itself signed by a
+// trusted key, so a key can only be trusted by someone already trusted.
+// Unsigned or untrusted history is refused unless --allow-unsigned is given.
+const (
+	TRUSTED_KEYS_FILE     = "trusted-keys.asc"
+	TRUSTED_KEYS_LOCATION = META_FOLDER_NAME + "/" + TRUSTED_KEYS_FILE
+	ALLOW_UNSIGNED_FLAG   = "--allow-unsigned"
+	SIGN_KEY_COMMENT      = "vstore"
+)
+
+// AllowUnsigned is set when pulling unsigned or untrusted commits is allowed.
+var AllowUnsigned bool
+
+// FlagRequested removes flag from args and tells whether it was there.
+func FlagRequested(args []string, flag string) ([]string, bool) {
+	kept := []string{}
+	found := false
+	for _, arg := range args {
+		if arg == flag {
+			found = true
+		} else {
+			kept = append(kept, arg)
+		}
+	}
+	return kept, found
+}
+
+// GenerateSignKey returns a new armored OpenPGP private key for name.
+func GenerateSignKey(name string) (string, error) {
+	entity, err := openpgp.NewEntity(name, SIGN_KEY_COMMENT, AUTHOR_EMAIL, nil)
+	if err != nil {
+		HandleErr(err, "Couldn't generate the signing key")
+		return "", err
+	}
+	var b bytes.Buffer
+	writer, err := armor.Encode(&b, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	err = entity.SerializePrivate(writer, nil)
+	if err != nil {
+		return "", err
+	}
+	err = writer.Close()
+	return b.String(), err
+}
+
+// ParseSignKey reads the armored private key of the settings.
+func ParseSignKey(armored string) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, err
+	}
+	if len(entities) != 1 || entities[0].PrivateKey == nil {
+		return nil, errors.New("the signing key must be a single private key")
+	}
+	return entities[0], nil
+}
+
+// KeyId returns the long id of an OpenPGP key as printed by gpg.
+func KeyId(entity *openpgp.Entity) string {
+	return fmt.Sprintf("%016X", entity.PrimaryKey.KeyId)
+}
+
+// readKeyRing parses armored public keys, an empty content has no key.
+func readKeyRing(armored []byte) (openpgp.EntityList, error) {
+	if len(bytes.TrimSpace(armored)) == 0 {
+		return openpgp.EntityList{}, nil
+	}
+	return openpgp.ReadArmoredKeyRing(bytes.NewReader(armored))
+}
+
+// armorKeyRing writes the public keys of entities in a single armored block,
+// the form Commit.Verify reads.
+func armorKeyRing(entities openpgp.EntityList) ([]byte, error) {
+	var b bytes.Buffer
+	writer, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range entities {
+		err = entity.Serialize(writer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writer.Close()
+	return b.Bytes(), err
+}
+
+func GetTrustedKeysPath() (string, error) {
+	path, err := GetMetaPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(path, TRUSTED_KEYS_FILE), nil
+}
+
+// GetTrustedKeys returns the keys of the trusted keys file, nil when the
+// store doesn't verify signatures.
+func GetTrustedKeys() (openpgp.EntityList, error) {
+	path, err := GetTrustedKeysPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		HandleErr(err, "Couldn't read the trusted keys")
+		return nil, err
+	}
+	return readKeyRing(b)
+}
+
+// saveTrustedKeys writes entities to the trusted keys file and commits it.
+func saveTrustedKeys(entities openpgp.EntityList, message string, keys *Keyring) error {
+	path, err := GetTrustedKeysPath()
+	if err != nil {
+		return err
+	}
+	b, err := armorKeyRing(entities)
+	if err != nil {
+		HandleErr(err, "Couldn't encode the trusted keys")
+		return err
+	}
+	err = CreateMetaDir()
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, b, 0644)
+	if err != nil {
+		HandleErr(err, fmt.Sprintf("Couldn't write the trusted keys at path %v", path))
+		return err
+	}
+	return StoreCommit([]string{path}, message, keys)
+}
+
+// Trust adds the armored public keys to the trusted keys, in a commit signed
+// by the user.
+func Trust(armored []byte, keys *Keyring) error {
+	if keys.SignKey == nil {
+		return errors.New("no signing key, create one with vstore sign-key first")
+	}
+	added, err := readKeyRing(armored)
+	if err != nil {
+		HandleErr(err, "Couldn't read the public keys")
+		return err
+	}
+	if len(added) == 0 {
+		return errors.New("no public key to trust")
+	}
+	trusted, err := GetTrustedKeys()
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	for _, entity := range added {
+		if len(trusted.KeysById(entity.PrimaryKey.KeyId)) > 0 {
+			continue
+		}
+		trusted = append(trusted, entity)
+		ids = append(ids, KeyId(entity))
+	}
+	if len(ids) == 0 {
+		fmt.Println("Already trusted")
+		return nil
+	}
+	err = saveTrustedKeys(trusted, fmt.Sprintf("Trust signing keys %s", strings.Join(ids, ", ")), keys)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Trusted %s\n", strings.Join(ids, ", "))
+	return nil
+}
+
+// Untrust removes the key with the long id from the trusted keys. The last
+// key can't be removed.
+func Untrust(id string, keys *Keyring) error {
+	trusted, err := GetTrustedKeys()
+	if err != nil {
+		return err
+	}
+	kept := openpgp.EntityList{}
+	for _, entity := range trusted {
+		if !strings.EqualFold(KeyId(entity), id) {
+			kept = append(kept, entity)
+		}
+	}
+	if len(kept) == len(trusted) {
+		return fmt.Errorf("%v is not a trusted key", id)
+	}
+	if len(kept) == 0 {
+		return errors.New("can't remove the last trusted key")
+	}
+	return saveTrustedKeys(kept, fmt.Sprintf("Untrust signing key %s", strings.ToUpper(id)), keys)
+}
+
+// ListTrusted prints the trusted keys with their user ids.
+func ListTrusted() error {
+	trusted, err := GetTrustedKeys()
+	if err != nil {
+		return err
+	}
+	if trusted == nil {
+		fmt.Println("Signatures are not verified, start with vstore sign-key")
+		return nil
+	}
+	for _, entity := range trusted {
+		names := []string{}
+		for name := range entity.Identities {
+			names = append(names, name)
+		}
+		fmt.Printf("%s %s\n", KeyId(entity), strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// SignKey creates the signing key of the user when there is none, trusts it
+// in the store and prints its public key, to be trusted by the others.
+func SignKey(password string, settings usersettings, name string, keys *Keyring) error {
+	if settings.SignKey == "" {
+		if name == "" {
+			name = settings.User
+		}
+		if name == "" {
+			name = AUTHOR_NAME
+		}
+		armored, err := GenerateSignKey(name)
+		if err != nil {
+			return err
+		}
+		settings.SignKey = armored
+		err = CreateEncodedSettingsFile(password, settings)
+		if err != nil {
+			HandleErr(err, "Couldn't save the signing key")
+			return err
+		}
+		keys.SignKey, err = ParseSignKey(armored)
+		if err != nil {
+			return err
+		}
+	}
+	trusted, err := GetTrustedKeys()
+	if err != nil {
+		return err
+	}
+	if trusted == nil {
+		// the first key, trusted by the store owner
+		err = saveTrustedKeys(openpgp.EntityList{keys.SignKey}, fmt.Sprintf("Trust signing keys %s", KeyId(keys.SignKey)), keys)
+		if err != nil {
+			return err
+		}
+	} else if len(trusted.KeysById(keys.SignKey.PrimaryKey.KeyId)) == 0 {
+		fmt.Println("Your key isn't trusted yet, ask a trusted user to run vstore trust with:")
+	}
+	public, err := armorKeyRing(openpgp.EntityList{keys.SignKey})
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(public))
+	return nil
+}
+
+// trustedKeysAt returns the armored trusted keys committed in commit, or an
+// empty string.
+func trustedKeysAt(commit *object.Commit) (string, error) {
+	file, err := commit.File(TRUSTED_KEYS_LOCATION)
+	if err != nil {
+		return "", nil
+	}
+	b, err := blobContent(file)
+	return string(b), err
+}
+
+// VerifyIncoming checks that the commits of the remote tracking branch
+// missing from the local branch are signed by keys trusted at the local
+// branch, oldest first. A trusted keys file changed by one of them is trusted
+// for the commits after it, so a key revoked locally can't sign or trust
+// itself again from older history. Nothing is checked when the local branch
+// has no trusted keys.
+func VerifyIncoming(repo *git.Repository) error {
+	if AllowUnsigned {
+		return nil
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil
+	}
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	armored, err := trustedKeysAt(headCommit)
+	if err != nil || armored == "" {
+		return err
+	}
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(REMOTE_NAME, head.Name().Short()), true)
+	if err != nil {
+		return nil
+	}
+	incoming, err := commitsOnlyIn(repo, remoteRef.Hash(), head.Hash())
+	if err != nil {
+		return err
+	}
+	for i := len(incoming) - 1; i >= 0; i-- {
+		commit := incoming[i]
+		subject := strings.SplitN(commit.Message, "\n", 2)[0]
+		if commit.PGPSignature == "" {
+			return fmt.Errorf("commit %s %q is not signed, pull it with %s if it is expected", shortHash(commit), subject, ALLOW_UNSIGNED_FLAG)
+		}
+		_, err = commit.Verify(armored)
+		if err != nil {
+			return fmt.Errorf("commit %s %q is not signed by a trusted key (%v), pull it with %s if it is expected", shortHash(commit), subject, err, ALLOW_UNSIGNED_FLAG)
+		}
+		changed, err := trustedKeysChangedIn(commit)
+		if err != nil {
+			return err
+		}
+		if changed != "" {
+			armored = changed
+		}
+	}
+	return nil
+}
+
+// trustedKeysChangedIn returns the trusted keys of a commit when it changes
+// them from every one of its parents, or nothing.
+func trustedKeysChangedIn(commit *object.Commit) (string, error) {
+	armored, err := trustedKeysAt(commit)
+	if err != nil || armored == "" {
+		return "", err
+	}
+	parents := commit.Parents()
+	defer parents.Close()
+	unchanged := false
+	err = parents.ForEach(func(parent *object.Commit) error {
+		parentKeys, err := trustedKeysAt(parent)
+		if err != nil {
+			return err
+		}
+		if parentKeys == armored {
+			unchanged = true
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil || unchanged {
+		return "", err
+	}
+	return armored, nil
+}
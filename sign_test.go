@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func testSignKey(t *testing.T, name string) *openpgp.Entity {
+	armored, err := GenerateSignKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity, err := ParseSignKey(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func TestArmorKeyRing(t *testing.T) {
+	alice, bob := testSignKey(t, "alice"), testSignKey(t, "bob")
+	armored, err := armorKeyRing(openpgp.EntityList{alice, bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, err := readKeyRing(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 2 || KeyId(entities[0]) != KeyId(alice) || KeyId(entities[1]) != KeyId(bob) {
+		t.Error("Expecting both keys back, got", entities)
+	}
+	if entities[0].PrivateKey != nil {
+		t.Error("Expecting only the public keys to be written")
+	}
+	_, err = ParseSignKey(string(armored))
+	if err == nil {
+		t.Error("Expecting public keys to be refused as signing key")
+	}
+}
+
+func TestVerifyIncoming(t *testing.T) {
+	alice, bob := testSignKey(t, "alice"), testSignKey(t, "bob")
+	repo, commitFiles := testRepo(t)
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(path string, content []byte, signKey *openpgp.Entity) plumbing.Hash {
+		return commitFiles(path, map[string]string{path: string(content)}, nil, signKey)
+	}
+	trustAlice, err := armorKeyRing(openpgp.EntityList{alice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustBoth, err := armorKeyRing(openpgp.EntityList{alice, bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := commit(TRUSTED_KEYS_LOCATION, trustAlice, alice)
+	byAlice := commit("store/a", []byte("1"), alice)
+	byBob := commit("store/a", []byte("2"), bob)
+	unsigned := commit("store/a", []byte("3"), nil)
+	err = worktree.Checkout(&git.CheckoutOptions{Hash: byAlice, Branch: "refs/heads/trust-bob", Create: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(TRUSTED_KEYS_LOCATION, trustBoth, alice)
+	trustedBob := commit("store/a", []byte("4"), bob)
+	revokedBob := commit(TRUSTED_KEYS_LOCATION, trustAlice, alice)
+	err = worktree.Checkout(&git.CheckoutOptions{Hash: trustedBob, Branch: "refs/heads/old-bob", Create: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byRevokedBob := commit("store/a", []byte("5"), bob)
+
+	master := plumbing.NewBranchReferenceName("master")
+	verifyFrom := func(local plumbing.Hash, remote plumbing.Hash) error {
+		for _, ref := range []*plumbing.Reference{
+			plumbing.NewSymbolicReference(plumbing.HEAD, master),
+			plumbing.NewHashReference(master, local),
+			plumbing.NewHashReference(plumbing.NewRemoteReferenceName(REMOTE_NAME, "master"), remote),
+		} {
+			err := repo.Storer.SetReference(ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return VerifyIncoming(repo)
+	}
+	verify := func(remote plumbing.Hash) error {
+		return verifyFrom(base, remote)
+	}
+	if err := verify(byAlice); err != nil {
+		t.Error("Expecting a commit signed by a trusted key to pass, got", err)
+	}
+	if err := verify(byBob); err == nil || !strings.Contains(err.Error(), "trusted key") {
+		t.Error("Expecting a commit signed by an untrusted key to fail, got", err)
+	}
+	if err := verify(unsigned); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Error("Expecting an unsigned commit to fail, got", err)
+	}
+	if err := verify(trustedBob); err != nil {
+		t.Error("Expecting a key trusted by a trusted commit to pass, got", err)
+	}
+	if err := verifyFrom(revokedBob, byRevokedBob); err == nil || !strings.Contains(err.Error(), "trusted key") {
+		t.Error("Expecting a commit signed by a key revoked locally to fail, got", err)
+	}
+	AllowUnsigned = true
+	defer func() { AllowUnsigned = false }()
+	if err := verify(unsigned); err != nil {
+		t.Error("Expecting unsigned commits to be allowed, got", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Value types accepted by set, the value is stored as a JSON string unless
+// one of the type flags is given.
+const (
+	VALUE_STRING = ""
+	VALUE_JSON   = "--json"
+	VALUE_NUMBER = "--number"
+	VALUE_BOOL   = "--bool"
+	VALUE_NULL   = "--null"
+)
+
+// IsValueType tells whether arg is one of the set type flags.
+func IsValueType(arg string) bool {
+	switch arg {
+	case VALUE_JSON, VALUE_NUMBER, VALUE_BOOL, VALUE_NULL:
+		return true
+	}
+	return false
+}
+
+// ParseValue converts input to the JSON value of type kind. Numbers keep their
+// exact text.
+func ParseValue(kind string, input string) (interface{}, error) {
+	switch kind {
+	case VALUE_STRING:
+		return input, nil
+	case VALUE_JSON:
+		var value interface{}
+		err := UnmarshalJson([]byte(input), &value)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a valid JSON value: %v", input, err)
+		}
+		return value, nil
+	case VALUE_NUMBER:
+		_, err := strconv.ParseFloat(input, 64)
+		if err != nil || !json.Valid([]byte(input)) {
+			return nil, fmt.Errorf("%v is not a valid JSON number", input)
+		}
+		return json.Number(input), nil
+	case VALUE_BOOL:
+		switch input {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, fmt.Errorf("%v is not a boolean, use true or false", input)
+	case VALUE_NULL:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown value type %v", kind)
+}
+
+// UnmarshalJson parses b into v like json.Unmarshal, but keeps numbers as
+// json.Number so they are written back with their exact text.
+func UnmarshalJson(b []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = errors.New("trailing data after the JSON value")
+	}
+	return err
+}
+
+// FormatValue returns strings as they are and any other value as JSON.
+func FormatValue(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		HandleErr(err, "Couldn't marshal JSON value")
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseValue(t *testing.T) {
+	cases := []struct {
+		kind     string
+		input    string
+		expected interface{}
+	}{
+		{VALUE_STRING, "8080", "8080"},
+		{VALUE_NUMBER, "8080", "8080"},
+		{VALUE_BOOL, "true", true},
+		{VALUE_NULL, "", nil},
+		{VALUE_JSON, `{"port": 8080, "tls": false}`, `{
+  "port": 8080,
+  "tls": false
+}`},
+	}
+	for _, c := range cases {
+		value, err := ParseValue(c.kind, c.input)
+		if err != nil {
+			t.Fatal(c.kind, err)
+		}
+		formatted, err := FormatValue(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, isString := c.expected.(string)
+		if !isString {
+			if !reflect.DeepEqual(value, c.expected) {
+				t.Error("Expecting", c.expected, "for", c.kind, c.input, "got", value)
+			}
+			continue
+		}
+		if formatted != expected {
+			t.Error("Expecting", expected, "for", c.kind, c.input, "got", formatted)
+		}
+	}
+	for _, c := range [][2]string{{VALUE_NUMBER, "0x10"}, {VALUE_NUMBER, "NaN"}, {VALUE_BOOL, "yes"}, {VALUE_JSON, "{"}, {VALUE_JSON, "1 2"}} {
+		_, err := ParseValue(c[0], c[1])
+		if err == nil {
+			t.Error("Expecting an error parsing", c[1], "as", c[0])
+		}
+	}
+}